@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cryptidcodes/chirpy/internal/auth"
+	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/cryptidcodes/chirpy/internal/mailer"
+	"github.com/google/uuid"
+)
+
+// exportRecord is one line of an account export, Type says what Data holds
+type exportRecord struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// handlerExportAccount sends everything stored about the caller as
+// newline-delimited JSON, one record per line
+func (cfg *apiConfig) handlerExportAccount(w http.ResponseWriter, r *http.Request) {
+	userID := principalFromContext(r.Context()).UserID
+
+	records, err := cfg.collectAccountExport(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't export account", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="chirpy-export.ndjson"`)
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	for _, record := range records {
+		err = enc.Encode(record)
+		if err != nil {
+			log.Printf("Error writing account export: %s", err)
+			return
+		}
+	}
+}
+
+// collectAccountExport reads the whole export up front, so a failing query
+// turns into an error response instead of a truncated file
+func (cfg *apiConfig) collectAccountExport(ctx context.Context, userID uuid.UUID) ([]exportRecord, error) {
+	user, err := cfg.dbQueries.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	records := []exportRecord{{Type: "profile", Data: User{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		PendingEmail:  user.PendingEmail.String,
+		IsChirpyRed:   user.IsChirpyRed,
+		EmailVerified: user.EmailVerified,
+		Role:          user.Role,
+	}}}
+
+	chirps, err := cfg.dbQueries.GetAllChirpsByUser(ctx, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		return nil, err
+	}
+	for _, chirp := range chirps {
+		records = append(records, exportRecord{Type: "chirp", Data: chirpFromDB(chirp)})
+	}
+
+	reactions, err := cfg.dbQueries.ListReactionsByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, reaction := range reactions {
+		records = append(records, exportRecord{Type: "reaction", Data: reactionFromDB(reaction)})
+	}
+
+	sessions, err := cfg.dbQueries.ListActiveSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		records = append(records, exportRecord{Type: "session", Data: Session{
+			ID:         session.FamilyID,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			UserAgent:  session.UserAgent,
+			IP:         session.IpAddress,
+		}})
+	}
+
+	pats, err := cfg.dbQueries.ListPersonalAccessTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, pat := range pats {
+		records = append(records, exportRecord{Type: "personal_access_token", Data: personalAccessTokenFromDB(pat)})
+	}
+
+	clients, err := cfg.dbQueries.ListOAuthClients(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, client := range clients {
+		records = append(records, exportRecord{Type: "oauth_client", Data: oauthClientFromDB(client)})
+	}
+
+	// the audit log is paged through like a client would, newest first
+	params := database.ListAuditEventsParams{
+		UserID:  uuid.NullUUID{UUID: userID, Valid: true},
+		MaxRows: maxAuditEventsLimit,
+	}
+	for {
+		events, err := cfg.dbQueries.ListAuditEvents(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			records = append(records, exportRecord{Type: "security_event", Data: auditEventFromDB(event)})
+		}
+		if len(events) < maxAuditEventsLimit {
+			break
+		}
+		params.BeforeID = uuid.NullUUID{UUID: events[len(events)-1].ID, Valid: true}
+	}
+
+	return records, nil
+}
+
+// handlerDeleteAccount disables the caller's account right away and leaves it
+// for purgeDeletedAccounts to remove once the grace period is over. Until
+// then the account can't be used and its email can't be registered again.
+func (cfg *apiConfig) handlerDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		CurrentPassword string `json:"current_password"`
+	}
+	type response struct {
+		DeletedAt time.Time `json:"deleted_at"`
+		PurgeAt   time.Time `json:"purge_at"`
+	}
+
+	caller := principalFromContext(r.Context())
+
+	// decode JSON request body
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+
+	// a stolen access token alone must not be enough to delete the account
+	accountKey := loginAccountKey(user.Email)
+	ipKey := loginIPKey(r)
+	if !cfg.reserveLoginAttempt(w, accountKey, ipKey) {
+		return
+	}
+	failed := false
+	defer cfg.settleLoginAttempt(accountKey, ipKey, &failed)
+	match, _, err := cfg.hasher.CheckPasswordHash(r.Context(), params.CurrentPassword, user.HashedPassword)
+	if errors.Is(err, auth.ErrHasherBusy) {
+		respondWithHashError(w, err)
+		return
+	}
+	if err != nil || !match {
+		failed = true
+		respondWithError(w, http.StatusUnauthorized, "Current password is incorrect", err)
+		return
+	}
+
+	deleted, err := cfg.softDeleteUser(r.Context(), user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete account", err)
+		return
+	}
+	cfg.recordSecurityEvent(r, user.ID, eventAccountDeleted, "")
+
+	// the grace period is only worth something if the user can use it
+	err = cfg.sendAccountRestoreEmail(r.Context(), deleted)
+	if err != nil {
+		log.Printf("Error sending account restore email: %s", err)
+	}
+
+	// the access token used here would keep working until it expires otherwise
+	token := caller.AccessToken
+	err = cfg.tokenDenylist.Revoke(r.Context(), token.ID, caller.UserID, token.ExpiresAt)
+	if err != nil {
+		log.Printf("Error revoking access token of deleted account: %s", err)
+	}
+	cfg.clearSessionCookies(w)
+
+	respondWithJSON(w, http.StatusAccepted, response{
+		DeletedAt: deleted.DeletedAt.Time,
+		PurgeAt:   deleted.DeletedAt.Time.Add(cfg.deletionGracePeriod),
+	})
+}
+
+// sendAccountRestoreEmail mails a link that undoes the deletion until the
+// account is purged. It is bound to this deletion, so a link from an earlier
+// deletion that was undone can't undo a later one.
+func (cfg *apiConfig) sendAccountRestoreEmail(ctx context.Context, user database.User) error {
+	token, err := auth.MakePurposeJWT(user.ID, auth.PurposeRestoreAccount, deletionBinding(user), cfg.jwtKeys, cfg.deletionGracePeriod)
+	if err != nil {
+		return err
+	}
+
+	link := cfg.appURL + "/restore-account?token=" + url.QueryEscape(token)
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy account has been deleted",
+		Body: fmt.Sprintf("Your Chirpy account has been deleted and will be removed for good on %s.\n\n"+
+			"Changed your mind? Follow this link before then to restore it:\n\n%s\n", user.DeletedAt.Time.Add(cfg.deletionGracePeriod).Format(time.RFC1123), link),
+	})
+}
+
+func deletionBinding(user database.User) string {
+	return user.DeletedAt.Time.UTC().Format(time.RFC3339Nano)
+}
+
+// handlerRestoreAccount undoes a deletion with the link mailed when it
+// happened. Sessions stay logged out and reactions stay taken back, the user
+// logs in again like after a password reset.
+func (cfg *apiConfig) handlerRestoreAccount(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+	type response struct {
+		User
+	}
+
+	// decode JSON request body
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	restore, err := auth.ValidatePurposeJWT(params.Token, auth.PurposeRestoreAccount, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired restore token", err)
+		return
+	}
+
+	deleted, err := cfg.dbQueries.GetDeletedUserByID(r.Context(), restore.UserID)
+	if err != nil || !restore.IsBoundTo(deletionBinding(deleted)) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired restore token", err)
+		return
+	}
+
+	// only restores the deletion the token was made for, a second use finds nothing
+	user, err := cfg.dbQueries.RestoreUser(r.Context(), database.RestoreUserParams{
+		ID:        deleted.ID,
+		DeletedAt: deleted.DeletedAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired restore token", err)
+		return
+	}
+	cfg.recordOwnerEvent(r, user.ID, eventAccountRestored, "")
+
+	respondWithJSON(w, http.StatusOK, response{
+		User: User{
+			ID:            user.ID,
+			CreatedAt:     user.CreatedAt,
+			UpdatedAt:     user.UpdatedAt,
+			Email:         user.Email,
+			IsChirpyRed:   user.IsChirpyRed,
+			EmailVerified: user.EmailVerified,
+			Role:          user.Role,
+		},
+	})
+}
+
+// softDeleteUser marks the user deleted, logs them out of every session,
+// revokes their invites and takes back their reactions in one transaction
+func (cfg *apiConfig) softDeleteUser(ctx context.Context, userID uuid.UUID) (database.User, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	deleted, err := qtx.SoftDeleteUser(ctx, userID)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	err = qtx.RevokeAllRefreshTokensForUser(ctx, userID)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	err = qtx.InvalidatePasswordResetTokens(ctx, userID)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	// an invite from a deleted account shouldn't still let people in
+	err = qtx.RevokeInvitesByCreator(ctx, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		return database.User{}, err
+	}
+
+	err = removeUserReactions(ctx, qtx, userID)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	return deleted, tx.Commit()
+}
+
+// purgeDeletedAccounts hard-deletes accounts whose grace period is over. It never returns.
+func (cfg *apiConfig) purgeDeletedAccounts(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		userIDs, err := cfg.dbQueries.ListUsersToPurge(ctx, sql.NullTime{
+			Time:  time.Now().Add(-cfg.deletionGracePeriod),
+			Valid: true,
+		})
+		if err != nil {
+			log.Printf("Error listing deleted accounts to purge: %s", err)
+		}
+		purged := 0
+		for _, userID := range userIDs {
+			// a failed account is left for the next run, nothing is lost by waiting
+			err = cfg.purgeUser(ctx, userID)
+			if err != nil {
+				log.Printf("Error purging deleted account %s: %s", userID, err)
+				continue
+			}
+			purged++
+		}
+		if purged > 0 {
+			log.Printf("Purged %d deleted accounts", purged)
+		}
+		cancel()
+	}
+}
+
+// purgeUser deletes the user's chirps the same way their author would, so
+// replies, quotes and rechirps of them are handled and the counts on other
+// chirps stay right, then deletes the user. The foreign keys take tokens and
+// everything else with it, tombstones that are still needed stay without an
+// author.
+func (cfg *apiConfig) purgeUser(ctx context.Context, userID uuid.UUID) error {
+	chirpIDs, err := cfg.dbQueries.ListChirpIDsByUser(ctx, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		return err
+	}
+	for _, chirpID := range chirpIDs {
+		// deleting an earlier chirp can take this one with it, like a rechirp of it
+		err = cfg.deleteChirp(ctx, chirpID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	// the foreign key would drop reactions without counting them down
+	err = removeUserReactions(ctx, qtx, userID)
+	if err != nil {
+		return err
+	}
+
+	// created_by goes NULL with the user, keep it distinguishable from admin key invites
+	err = qtx.MarkInvitesCreatorDeleted(ctx, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		return err
+	}
+
+	_, err = qtx.PurgeUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
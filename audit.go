@@ -0,0 +1,220 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// event types written to the audit_events table
+const (
+	eventLogin                = "login"
+	eventLoginFailed          = "login_failed"
+	eventCredentialsChanged   = "credentials_changed"
+	eventEmailChangeRequested = "email_change_requested"
+	eventEmailChanged         = "email_changed"
+	eventAccountDeleted       = "account_deleted"
+	eventAccountRestored      = "account_restored"
+	eventPasswordReset        = "password_reset"
+	eventTokenRefreshed       = "token_refreshed"
+	eventTokenRevoked         = "token_revoked"
+	eventRefreshTokenReused   = "refresh_token_reused"
+	eventLogout               = "logout"
+	eventSessionRevoked       = "session_revoked"
+	eventChirpyRedUpgraded    = "chirpy_red_upgraded"
+	eventTwoFactorEnabled     = "2fa_enabled"
+	eventTwoFactorDisabled    = "2fa_disabled"
+	eventAccessTokenCreated   = "access_token_created"
+	eventAccessTokenRevoked   = "access_token_revoked"
+	eventAdminReset           = "admin_reset"
+	eventAdminAccountUnlocked = "admin_account_unlocked"
+	eventAdminIPUnlocked      = "admin_ip_unlocked"
+	eventAdminRoleChanged     = "admin_role_changed"
+	eventAdminInviteCreated   = "admin_invite_created"
+	eventAdminInviteRevoked   = "admin_invite_revoked"
+)
+
+const (
+	defaultAuditEventsLimit = 50
+	maxAuditEventsLimit     = 200
+)
+
+// DO NOT DELETE: USED IN RESPONSE STRUCTURES
+// database.AuditEvent DOES NOT HAVE JSON TAGS
+type AuditEvent struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UserID    *uuid.UUID `json:"user_id"`
+	ActorID   *uuid.UUID `json:"actor_id"`
+	Actor     string     `json:"actor"`
+	EventType string     `json:"event_type"`
+	Detail    string     `json:"detail"`
+	IP        string     `json:"ip"`
+	UserAgent string     `json:"user_agent"`
+}
+
+func auditEventFromDB(event database.AuditEvent) AuditEvent {
+	resp := AuditEvent{
+		ID:        event.ID,
+		CreatedAt: event.CreatedAt,
+		Actor:     event.Actor,
+		EventType: event.EventType,
+		Detail:    event.Detail,
+		IP:        event.IpAddress,
+		UserAgent: event.UserAgent,
+	}
+	if event.UserID.Valid {
+		resp.UserID = &event.UserID.UUID
+	}
+	if event.ActorID.Valid {
+		resp.ActorID = &event.ActorID.UUID
+	}
+	return resp
+}
+
+// recordSecurityEvent writes an event about userID's account, which may be
+// uuid.Nil if the account is unknown. The actor is the authenticated caller.
+// Requests without one are anonymous, failed logins and reused refresh tokens
+// are usually someone other than the owner. Failures are logged, not
+// returned: a broken audit log must not lock users out.
+func (cfg *apiConfig) recordSecurityEvent(r *http.Request, userID uuid.UUID, eventType, detail string) {
+	actor := "anonymous"
+	actorID := uuid.NullUUID{}
+
+	if p := principalFromContext(r.Context()); p != nil {
+		actorID = uuid.NullUUID{UUID: p.UserID, Valid: true}
+		switch {
+		case p.AdminKey:
+			actor = "admin_key"
+			actorID = uuid.NullUUID{}
+		case p.AccessToken.ClientID != "":
+			actor = "oauth_client:" + p.AccessToken.ClientID
+		case p.Scopes != nil:
+			actor = "personal_access_token"
+		default:
+			actor = "user"
+		}
+	}
+
+	cfg.recordAuditEvent(r, actor, actorID, userID, eventType, detail)
+}
+
+// recordOwnerEvent writes an event for a request without a caller that has
+// just proven it holds the account's credentials, like a correct password,
+// a valid refresh token or a link from the account's email. The owner is the
+// actor.
+func (cfg *apiConfig) recordOwnerEvent(r *http.Request, userID uuid.UUID, eventType, detail string) {
+	cfg.recordAuditEvent(r, "user", uuid.NullUUID{UUID: userID, Valid: true}, userID, eventType, detail)
+}
+
+// recordAuditEvent writes an event with an explicit actor, for callers like
+// webhooks that aren't users at all
+func (cfg *apiConfig) recordAuditEvent(r *http.Request, actor string, actorID uuid.NullUUID, userID uuid.UUID, eventType, detail string) {
+	err := cfg.dbQueries.CreateAuditEvent(r.Context(), database.CreateAuditEventParams{
+		UserID:    uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil},
+		ActorID:   actorID,
+		Actor:     actor,
+		EventType: eventType,
+		Detail:    detail,
+		IpAddress: clientIP(r),
+		UserAgent: userAgent(r),
+	})
+	if err != nil {
+		log.Printf("Error writing audit event %s: %s", eventType, err)
+	}
+}
+
+func (cfg *apiConfig) handlerListSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	userID := principalFromContext(r.Context()).UserID
+
+	params, ok := auditEventsPage(w, r)
+	if !ok {
+		return
+	}
+	params.UserID = uuid.NullUUID{UUID: userID, Valid: true}
+
+	cfg.respondWithAuditEvents(w, r, params)
+}
+
+func (cfg *apiConfig) handlerAdminListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	params, ok := auditEventsPage(w, r)
+	if !ok {
+		return
+	}
+	if s := query.Get("user_id"); s != "" {
+		userID, err := uuid.Parse(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user_id", err)
+			return
+		}
+		params.UserID = uuid.NullUUID{UUID: userID, Valid: true}
+	}
+	if s := query.Get("event_type"); s != "" {
+		params.EventType = sql.NullString{String: s, Valid: true}
+	}
+	if s := query.Get("ip"); s != "" {
+		params.IpAddress = sql.NullString{String: s, Valid: true}
+	}
+	for name, dst := range map[string]*sql.NullTime{"since": &params.Since, "until": &params.Until} {
+		s := query.Get(name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid "+name+", use RFC 3339", err)
+			return
+		}
+		// created_at is a UTC timestamp without a zone, compare in UTC
+		*dst = sql.NullTime{Time: t.UTC(), Valid: true}
+	}
+
+	cfg.respondWithAuditEvents(w, r, params)
+}
+
+// auditEventsPage reads the limit and before query parameters shared by both listings
+func auditEventsPage(w http.ResponseWriter, r *http.Request) (database.ListAuditEventsParams, bool) {
+	params := database.ListAuditEventsParams{MaxRows: defaultAuditEventsLimit}
+	query := r.URL.Query()
+
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxAuditEventsLimit {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxAuditEventsLimit), err)
+			return params, false
+		}
+		params.MaxRows = int32(limit)
+	}
+	// before is the id of the last event of the previous page
+	if s := query.Get("before"); s != "" {
+		before, err := uuid.Parse(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid before", err)
+			return params, false
+		}
+		params.BeforeID = uuid.NullUUID{UUID: before, Valid: true}
+	}
+	return params, true
+}
+
+func (cfg *apiConfig) respondWithAuditEvents(w http.ResponseWriter, r *http.Request, params database.ListAuditEventsParams) {
+	events, err := cfg.dbQueries.ListAuditEvents(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve events", err)
+		return
+	}
+
+	resp := make([]AuditEvent, len(events))
+	for i := range events {
+		resp[i] = auditEventFromDB(events[i])
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
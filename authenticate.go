@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+
+	"github.com/cryptidcodes/chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+const (
+	roleUser      = "user"
+	roleModerator = "moderator"
+	roleAdmin     = "admin"
+)
+
+// each role can do everything the roles below it can
+var roleRank = map[string]int{
+	roleUser:      1,
+	roleModerator: 2,
+	roleAdmin:     3,
+}
+
+// principal is the authenticated caller of a request
+type principal struct {
+	UserID        uuid.UUID
+	Role          string
+	EmailVerified bool
+	// Scopes is nil for access tokens, which can do anything the user can.
+	// Personal access tokens and tokens of third-party clients are limited
+	// to the scopes they were granted.
+	Scopes []string
+	// AccessToken is the validated JWT, it is empty for other kinds of credentials
+	AccessToken auth.AccessToken
+	// AdminKey is set when the caller used ADMIN_KEY rather than a user's
+	// credentials, it only works on routes wrapped in requireAdmin
+	AdminKey bool
+}
+
+func (p *principal) hasRole(role string) bool {
+	return roleRank[p.Role] >= roleRank[role]
+}
+
+func (p *principal) hasScope(scope string) bool {
+	return p.Scopes == nil || auth.HasScope(p.Scopes, scope)
+}
+
+type contextKey int
+
+const (
+	principalKey contextKey = iota
+	// csrfRejectedKey marks requests whose session cookie was ignored for failing the CSRF check
+	csrfRejectedKey
+)
+
+// principalFromContext returns the caller stored by middlewareAuthenticate, or nil
+func principalFromContext(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey).(*principal)
+	return p
+}
+
+// middlewareAuthenticate resolves the caller from either a JWT in the Bearer
+// scheme or a personal access token in the ApiKey (or Bearer) scheme, and
+// stores it in the request context. Browser sessions send their JWT in a
+// cookie instead, which only counts if the request passes the CSRF check.
+// Requests without valid credentials pass through without a principal, the
+// require* wrappers decide what to do with them. It only runs on routes
+// wrapped in one of them or in optionalAuth, so static files and public
+// endpoints never pay for a token lookup.
+func (cfg *apiConfig) middlewareAuthenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if token, fromCookie := credentialsFromRequest(r); token != "" {
+			p := cfg.resolvePrincipal(r, token)
+			switch {
+			case p == nil:
+			case fromCookie && p.AccessToken.ID == "":
+				// only access tokens are ever stored in the cookie
+			case fromCookie && !validCSRF(r):
+				ctx = context.WithValue(ctx, csrfRejectedKey, true)
+			default:
+				ctx = context.WithValue(ctx, principalKey, p)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// credentialsFromRequest prefers the Authorization header, so API clients
+// keep working the same even if the browser also has a session cookie
+func credentialsFromRequest(r *http.Request) (token string, fromCookie bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err == nil {
+		return token, false
+	}
+	token, err = auth.GetAPIKey(r.Header)
+	if err == nil {
+		return token, false
+	}
+	if c, err := r.Cookie(accessCookie); err == nil {
+		return c.Value, true
+	}
+	return "", false
+}
+
+func (cfg *apiConfig) resolvePrincipal(r *http.Request, token string) *principal {
+	// the admin key is a credential of its own, not tied to any user
+	if cfg.adminKey != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.adminKey)) == 1 {
+		return &principal{Role: roleAdmin, AdminKey: true}
+	}
+
+	var userID uuid.UUID
+	var scopes []string
+	var accessToken auth.AccessToken
+	if auth.IsPersonalAccessToken(token) {
+		pat, err := cfg.dbQueries.GetPersonalAccessTokenByHash(r.Context(), auth.HashToken(token))
+		if err != nil {
+			return nil
+		}
+		err = cfg.dbQueries.TouchPersonalAccessToken(r.Context(), pat.ID)
+		if err != nil {
+			log.Printf("Error updating token last use: %s", err)
+		}
+		userID = pat.UserID
+		scopes = pat.Scopes
+		if scopes == nil {
+			scopes = []string{}
+		}
+	} else {
+		// other ApiKey credentials and refresh tokens aren't JWTs and simply fail here
+		var err error
+		accessToken, err = auth.ValidateJWT(token, cfg.jwtKeys)
+		if err != nil {
+			return nil
+		}
+		// tokens from a logout stay valid on paper until they expire
+		if cfg.tokenDenylist.Contains(accessToken.ID) {
+			return nil
+		}
+		userID = accessToken.UserID
+		// tokens issued to third-party clients are limited like personal access tokens
+		scopes = accessToken.Scopes
+	}
+
+	// the role can change at any time, so it is looked up rather than trusted from the token
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		return nil
+	}
+
+	return &principal{
+		UserID:        user.ID,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+		Scopes:        scopes,
+		AccessToken:   accessToken,
+	}
+}
+
+// principalOrReject returns the caller, or responds with an error and returns nil if there is none
+func principalOrReject(w http.ResponseWriter, r *http.Request) *principal {
+	p := principalFromContext(r.Context())
+	if p != nil {
+		return p
+	}
+	if rejected, _ := r.Context().Value(csrfRejectedKey).(bool); rejected {
+		respondWithError(w, http.StatusForbidden, "Missing or invalid CSRF token", nil)
+		return nil
+	}
+	respondWithError(w, http.StatusUnauthorized, "Missing or invalid token", nil)
+	return nil
+}
+
+// requireAuth only lets callers with an access token through. Personal access
+// tokens and third-party client tokens are rejected, they only work on routes
+// wrapped in requireScope.
+func (cfg *apiConfig) requireAuth(next http.HandlerFunc) http.Handler {
+	return cfg.middlewareAuthenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := principalOrReject(w, r)
+		if p == nil {
+			return
+		}
+		if p.Scopes != nil {
+			respondWithError(w, http.StatusForbidden, "Scoped tokens can't be used here", nil)
+			return
+		}
+		if p.AdminKey {
+			respondWithError(w, http.StatusForbidden, "The admin key can only be used on admin endpoints", nil)
+			return
+		}
+		next(w, r)
+	}))
+}
+
+// requireScope lets through access tokens and scoped tokens granted scope
+func (cfg *apiConfig) requireScope(scope string, next http.HandlerFunc) http.Handler {
+	return cfg.middlewareAuthenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := principalOrReject(w, r)
+		if p == nil {
+			return
+		}
+		if p.AdminKey {
+			respondWithError(w, http.StatusForbidden, "The admin key can only be used on admin endpoints", nil)
+			return
+		}
+		if !p.hasScope(scope) {
+			respondWithError(w, http.StatusForbidden, "Token does not have the required scope", nil)
+			return
+		}
+		next(w, r)
+	}))
+}
+
+// optionalAuth resolves the caller if there is one and lets every request
+// through, for routes that only behave differently for signed in users
+func (cfg *apiConfig) optionalAuth(next http.HandlerFunc) http.Handler {
+	return cfg.middlewareAuthenticate(next)
+}
+
+// requireRole lets through access tokens of users with at least the given role
+func (cfg *apiConfig) requireRole(role string, next http.HandlerFunc) http.Handler {
+	return cfg.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if !principalFromContext(r.Context()).hasRole(role) {
+			respondWithError(w, http.StatusForbidden, "You do not have permission to do this", nil)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// requireAdmin lets through admins with an access token and callers with the admin key
+func (cfg *apiConfig) requireAdmin(next http.HandlerFunc) http.Handler {
+	return cfg.middlewareAuthenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := principalOrReject(w, r)
+		if p == nil {
+			return
+		}
+		if !p.AdminKey && (p.Scopes != nil || !p.hasRole(roleAdmin)) {
+			respondWithError(w, http.StatusForbidden, "You do not have permission to do this", nil)
+			return
+		}
+		next(w, r)
+	}))
+}
@@ -1,55 +1,69 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/cryptidcodes/chirpy/internal/auth"
 	"github.com/cryptidcodes/chirpy/internal/database"
 	"github.com/google/uuid"
 )
 
+// chirp kinds, stored in chirps.kind
+const (
+	chirpKindChirp   = "chirp"
+	chirpKindRechirp = "rechirp"
+	chirpKindQuote   = "quote"
+)
+
 // DO NOT DELETE: USED IN RESPONSE STRUCTURES
 // database.Chirps DOES NOT HAVE JSON TAGS
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+	ID           uuid.UUID        `json:"id"`
+	CreatedAt    time.Time        `json:"created_at"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+	Body         string           `json:"body"`
+	UserID       uuid.UUID        `json:"user_id"`
+	Edited       bool             `json:"edited"`
+	InReplyTo    *uuid.UUID       `json:"in_reply_to"`
+	ReplyCount   int32            `json:"reply_count"`
+	Deleted      bool             `json:"deleted"`
+	Kind         string           `json:"kind"`
+	OriginalID   *uuid.UUID       `json:"original_id"`
+	RechirpCount int32            `json:"rechirp_count"`
+	QuoteCount   int32            `json:"quote_count"`
+	Reactions    map[string]int32 `json:"reactions"`
 }
 
 func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request) {
 	// specify request and response structures
 	type parameters struct {
-		Body   string    `json:"body"`
-		UserID uuid.UUID `json:"user_id"`
+		Body      string     `json:"body"`
+		UserID    uuid.UUID  `json:"user_id"`
+		InReplyTo *uuid.UUID `json:"in_reply_to"`
+		QuoteOf   *uuid.UUID `json:"quote_of"`
 	}
 
-	// validate JWT from headers
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Missing or invalid token", err)
-		return
-	}
-	if token == "" {
-		respondWithError(w, http.StatusUnauthorized, "Missing or invalid token", nil)
-		return
-	}
-	UserID, err := auth.ValidateJWT(token, cfg.secretKey)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid token", err)
+	caller := principalFromContext(r.Context())
+
+	// only users with a verified email address can post
+	if !caller.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "Email address must be verified before posting chirps", nil)
 		return
 	}
 
 	// decode the incoming JSON body into a parameters struct
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		log.Printf("Error decoding parameters: %s", err)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
@@ -57,17 +71,109 @@ func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request)
 	}
 	// params is now a struct with data populated successfully
 
-	// validate the body length
-	const maxChirpLength = 140
-	if len(params.Body) > maxChirpLength {
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
+	cleaned, err := cleanChirpBody(params.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
 		return
 	}
 
+	// CREATE SQL ENTRY
+	chirpParams := database.CreateChirpParams{
+		Body:   cleaned,
+		UserID: uuid.NullUUID{UUID: caller.UserID, Valid: true},
+		Kind:   chirpKindChirp,
+	}
+	if params.InReplyTo != nil {
+		chirpParams.InReplyTo = uuid.NullUUID{UUID: *params.InReplyTo, Valid: true}
+	}
+	if params.QuoteOf != nil {
+		chirpParams.Kind = chirpKindQuote
+		chirpParams.OriginalID = uuid.NullUUID{UUID: *params.QuoteOf, Valid: true}
+	}
+
+	chirp, err := cfg.createChirp(r.Context(), chirpParams)
+	if errors.Is(err, errReplyParentNotFound) {
+		respondWithError(w, http.StatusNotFound, "Chirp being replied to not found", err)
+		return
+	}
+	if errors.Is(err, errQuotedNotFound) {
+		respondWithError(w, http.StatusNotFound, "Chirp being quoted not found", err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating chirp in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp", err)
+		return
+	}
+
+	// RESPOND WITH CLEANED CHIRP
+	respondWithJSON(w, http.StatusCreated, chirpFromDB(chirp))
+}
+
+var (
+	errReplyParentNotFound = errors.New("chirp being replied to not found")
+	errQuotedNotFound      = errors.New("chirp being quoted not found")
+)
+
+// createChirp stores a chirp, and for replies and quotes bumps the counts of
+// the chirps they refer to in the same transaction. Deleted chirps and
+// rechirps can't be replied to or quoted.
+func (cfg *apiConfig) createChirp(ctx context.Context, params database.CreateChirpParams) (database.Chirp, error) {
+	if !params.InReplyTo.Valid && !params.OriginalID.Valid {
+		return cfg.dbQueries.CreateChirp(ctx, params)
+	}
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	if params.InReplyTo.Valid {
+		updated, err := qtx.IncrementReplyCount(ctx, params.InReplyTo.UUID)
+		if err != nil {
+			return database.Chirp{}, err
+		}
+		if updated == 0 {
+			return database.Chirp{}, errReplyParentNotFound
+		}
+	}
+
+	if params.OriginalID.Valid {
+		updated, err := qtx.IncrementQuoteCount(ctx, params.OriginalID.UUID)
+		if err != nil {
+			return database.Chirp{}, err
+		}
+		if updated == 0 {
+			return database.Chirp{}, errQuotedNotFound
+		}
+	}
+
+	chirp, err := qtx.CreateChirp(ctx, params)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+
+	return chirp, tx.Commit()
+}
+
+const maxChirpLength = 140
+
+var errChirpTooLong = errors.New("Chirp is too long")
+
+// cleanChirpBody checks the length of a chirp and replaces bad words, every
+// body goes through it before it is stored
+func cleanChirpBody(body string) (string, error) {
+	// validate the body length
+	if len(body) > maxChirpLength {
+		return "", errChirpTooLong
+	}
+
 	// replace bad words
 	strictWords := []string{"kerfuffle", "sharbert", "fornax"}
 	cleanedWords := []string{}
-	uncleaned := strings.Split(params.Body, " ")
+	uncleaned := strings.Split(body, " ")
 	for _, word := range uncleaned {
 		for _, badWord := range strictWords {
 			if strings.ToLower(word) == badWord {
@@ -76,99 +182,192 @@ func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request)
 		}
 		cleanedWords = append(cleanedWords, word)
 	}
-	cleaned := strings.Join(cleanedWords, " ")
+	return strings.Join(cleanedWords, " "), nil
+}
 
-	// CREATE SQL ENTRY
-	chirpParams := database.CreateChirpParams{
-		Body:   cleaned,
-		UserID: UserID,
+const (
+	defaultChirpsLimit = 20
+	maxChirpsLimit     = 100
+)
+
+// pageParams reads the limit and cursor query parameters of the paged chirp
+// lists. The cursor comes back as is since threads use a different kind.
+func pageParams(w http.ResponseWriter, r *http.Request) (int, string, bool) {
+	q := r.URL.Query()
+	limit := defaultChirpsLimit
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxChirpsLimit {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxChirpsLimit), err)
+			return 0, "", false
+		}
+		limit = n
 	}
+	return limit, q.Get("cursor"), true
+}
 
-	chirp, err := cfg.dbQueries.CreateChirp(r.Context(), chirpParams)
+// chirpPageParams is pageParams for lists paged by created_at and id
+func chirpPageParams(w http.ResponseWriter, r *http.Request) (int, sql.NullTime, uuid.NullUUID, bool) {
+	limit, cursor, ok := pageParams(w, r)
+	if !ok || cursor == "" {
+		return limit, sql.NullTime{}, uuid.NullUUID{}, ok
+	}
+	createdAt, id, err := decodeChirpCursor(cursor)
 	if err != nil {
-		log.Printf("Error creating chirp in database: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp", err)
-		return
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+		return 0, sql.NullTime{}, uuid.NullUUID{}, false
 	}
+	return limit, sql.NullTime{Time: createdAt, Valid: true}, uuid.NullUUID{UUID: id, Valid: true}, true
+}
 
-	// RESPOND WITH CLEANED CHIRP
-	respondWithJSON(w, http.StatusCreated, Chirp{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID,
-	})
+// chirpFromDB hides the body and author of deleted chirps, they are only
+// still around as tombstones holding their replies together. Tombstones of
+// purged accounts have no author at all.
+func chirpFromDB(chirp database.Chirp) Chirp {
+	resp := Chirp{
+		ID:           chirp.ID,
+		CreatedAt:    chirp.CreatedAt,
+		UpdatedAt:    chirp.UpdatedAt,
+		Body:         chirp.Body,
+		UserID:       chirp.UserID.UUID,
+		Edited:       chirp.EditedAt.Valid,
+		ReplyCount:   chirp.ReplyCount,
+		Deleted:      chirp.DeletedAt.Valid,
+		Kind:         chirp.Kind,
+		RechirpCount: chirp.RechirpCount,
+		QuoteCount:   chirp.QuoteCount,
+		Reactions:    reactionCountsFromDB(chirp.ReactionCounts),
+	}
+	if chirp.InReplyTo.Valid {
+		resp.InReplyTo = &chirp.InReplyTo.UUID
+	}
+	if chirp.OriginalID.Valid {
+		resp.OriginalID = &chirp.OriginalID.UUID
+	}
+	if resp.Deleted {
+		resp.Body = ""
+		resp.UserID = uuid.Nil
+		resp.Reactions = map[string]int32{}
+	}
+	return resp
 }
 
+// handlerGetAllChirps lists chirps oldest first, or newest first with
+// sort=desc. Without limit or cursor it returns a plain array like it always
+// has, capped at defaultChirpsLimit chirps. With either of them it returns a
+// page and the cursor for the next one.
 func (cfg *apiConfig) handlerGetAllChirps(w http.ResponseWriter, r *http.Request) {
-	// returns all chirps in the db
+	type response struct {
+		Chirps     []Chirp `json:"chirps"`
+		NextCursor *string `json:"next_cursor"`
+	}
 
 	// check query params
 	q := r.URL.Query()
-	author_ID := q.Get("author_id")
-	sortOrder := q.Get("sort")
-	if author_ID != "" {
+	params := database.ListChirpsAscParams{}
+
+	if author_ID := q.Get("author_id"); author_ID != "" {
 		userID, err := uuid.Parse(author_ID)
 		if err != nil {
 			respondWithError(w, http.StatusBadRequest, "couldn't parse userID", err)
 			return
 		}
-
-		chirps, err := cfg.dbQueries.GetAllChirpsByUser(r.Context(), userID)
+		params.AuthorID = uuid.NullUUID{UUID: userID, Valid: true}
+	}
+	for name, dst := range map[string]*sql.NullTime{"since": &params.Since, "until": &params.Until} {
+		s := q.Get(name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
 		if err != nil {
-			respondWithError(w, http.StatusNotFound, "couldnt get user's chirps", err)
+			respondWithError(w, http.StatusBadRequest, "Invalid "+name+", use RFC 3339", err)
 			return
 		}
+		// created_at is a UTC timestamp without a zone, compare in UTC
+		*dst = sql.NullTime{Time: t.UTC(), Valid: true}
+	}
 
-		resp := make([]Chirp, len(chirps))
-		// parse chirps into response format
-		for i := range chirps {
-			resp[i] = Chirp{
-				ID:        chirps[i].ID,
-				CreatedAt: chirps[i].CreatedAt,
-				UpdatedAt: chirps[i].UpdatedAt,
-				Body:      chirps[i].Body,
-				UserID:    chirps[i].UserID,
-			}
-		}
-
-		// sort if needed
-		if sortOrder == "desc" {
-			// simple bubble sort for descending order by CreatedAt
-			sort.Slice(resp, func(i, j int) bool { return resp[i].CreatedAt.After(resp[j].CreatedAt) })
-		}
-
-		respondWithJSON(w, http.StatusOK, resp)
+	paginated := q.Has("limit") || q.Has("cursor")
+	limit, cursorCreatedAt, cursorID, ok := chirpPageParams(w, r)
+	if !ok {
 		return
 	}
+	params.CursorCreatedAt = cursorCreatedAt
+	params.CursorID = cursorID
+	// one extra row tells us whether there is another page
+	params.MaxRows = sql.NullInt32{Int32: int32(limit) + 1, Valid: true}
 
-	chirps, err := cfg.dbQueries.GetAllChirps(r.Context())
+	var chirps []database.Chirp
+	var err error
+	switch q.Get("sort") {
+	case "", "asc":
+		chirps, err = cfg.dbQueries.ListChirpsAsc(r.Context(), params)
+	case "desc":
+		chirps, err = cfg.dbQueries.ListChirpsDesc(r.Context(), database.ListChirpsDescParams(params))
+	default:
+		respondWithError(w, http.StatusBadRequest, "sort must be asc or desc", nil)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps", err)
 		return
 	}
 
-	resp := make([]Chirp, len(chirps))
+	var nextCursor *string
+	if len(chirps) > limit {
+		chirps = chirps[:limit]
+		last := chirps[limit-1]
+		cursor := encodeChirpCursor(last.CreatedAt, last.ID)
+		nextCursor = &cursor
+	}
+
 	// parse chirps into response format
+	resp := make([]Chirp, len(chirps))
 	for i := range chirps {
-		resp[i] = Chirp{
-			ID:        chirps[i].ID,
-			CreatedAt: chirps[i].CreatedAt,
-			UpdatedAt: chirps[i].UpdatedAt,
-			Body:      chirps[i].Body,
-			UserID:    chirps[i].UserID,
-		}
+		resp[i] = chirpFromDB(chirps[i])
 	}
 
-	// sort if needed
-	if sortOrder == "desc" {
-		// simple bubble sort for descending order by CreatedAt
-		sort.Slice(resp, func(i, j int) bool { return resp[i].CreatedAt.After(resp[j].CreatedAt) })
+	// respond with JSON, the plain array has no room for the cursor so
+	// clients that predate paging can still tell the list was cut short
+	if !paginated {
+		if nextCursor != nil {
+			w.Header().Set("X-Next-Cursor", *nextCursor)
+		}
+		respondWithJSON(w, http.StatusOK, resp)
+		return
 	}
+	respondWithJSON(w, http.StatusOK, response{
+		Chirps:     resp,
+		NextCursor: nextCursor,
+	})
+}
 
-	// respond with JSON
-	respondWithJSON(w, http.StatusOK, resp)
+// encodeChirpCursor packs the position after a chirp into an opaque string.
+// Clients should pass it back as is, the format may change.
+func encodeChirpCursor(createdAt time.Time, id uuid.UUID) string {
+	raw := createdAt.Format(time.RFC3339Nano) + "|" + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeChirpCursor(cursor string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	ts, idString, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, uuid.Nil, errors.New("malformed cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	id, err := uuid.Parse(idString)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	return createdAt, id, nil
 }
 
 func (cfg *apiConfig) handlerGetChirpByID(w http.ResponseWriter, r *http.Request) {
@@ -187,59 +386,434 @@ func (cfg *apiConfig) handlerGetChirpByID(w http.ResponseWriter, r *http.Request
 	}
 
 	// respond with JSON
-	respondWithJSON(w, http.StatusOK, Chirp{
-		ID:        c.ID,
-		CreatedAt: c.CreatedAt,
-		UpdatedAt: c.UpdatedAt,
-		Body:      c.Body,
-		UserID:    c.UserID,
-	})
+	respondWithJSON(w, http.StatusOK, chirpFromDB(c))
 }
 
 func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
-	// authenticate user via JWT
-	token, err := auth.GetBearerToken(r.Header)
+	caller := principalFromContext(r.Context())
+
+	// extract chirpID from URL
+	chirpIDstring := r.PathValue("chirpID")
+	chirpID, err := uuid.Parse(chirpIDstring)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	// get chirp to verify it exists, tombstones are already deleted
+	chirp, err := cfg.dbQueries.GetChirpByID(r.Context(), chirpID)
+	if err == nil && chirp.DeletedAt.Valid {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Chirp not found", err)
+		return
+	}
+
+	// only the owner of the chirp, or a moderator, can delete it
+	if chirp.UserID.UUID != caller.UserID && !caller.hasRole(roleModerator) {
+		respondWithError(w, http.StatusForbidden, "You do not have permission to delete this chirp", nil)
+		return
+	}
+
+	err = cfg.deleteChirp(r.Context(), chirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
+		return
+	}
+
+	// respond with no content
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// deleteChirp removes a chirp, or turns it into a tombstone if it has replies
+// or quotes so they don't lose what they refer to. Rechirps of it go with it.
+// A tombstone is removed once nothing refers to it anymore, all the way up
+// the thread.
+func (cfg *apiConfig) deleteChirp(ctx context.Context, chirpID uuid.UUID) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	current, err := qtx.GetChirpForUpdate(ctx, chirpID)
+	if err != nil {
+		return err
+	}
+	if current.DeletedAt.Valid {
+		return sql.ErrNoRows
+	}
+
+	// a deleted rechirp or quote no longer counts towards its original
+	if current.OriginalID.Valid {
+		switch current.Kind {
+		case chirpKindRechirp:
+			err = qtx.DecrementRechirpCount(ctx, current.OriginalID.UUID)
+			if err != nil {
+				return err
+			}
+		case chirpKindQuote:
+			original, err := qtx.DecrementQuoteCount(ctx, current.OriginalID.UUID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			if err == nil {
+				err = pruneTombstones(ctx, qtx, original)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	err = qtx.DeleteRechirpsOf(ctx, uuid.NullUUID{UUID: chirpID, Valid: true})
+	if err != nil {
+		return err
+	}
+
+	if current.ReplyCount > 0 || current.QuoteCount > 0 {
+		_, err = qtx.TombstoneChirp(ctx, chirpID)
+		if err != nil {
+			return err
+		}
+		// the old bodies go too, a tombstone keeps nothing that was said
+		err = qtx.DeleteChirpRevisions(ctx, chirpID)
+		if err != nil {
+			return err
+		}
+		err = qtx.DeleteChirpReactions(ctx, chirpID)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	err = qtx.DeleteChirp(ctx, chirpID)
+	if err != nil {
+		return err
+	}
+
+	if current.InReplyTo.Valid {
+		parent, err := qtx.DecrementReplyCount(ctx, current.InReplyTo.UUID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err == nil {
+			err = pruneTombstones(ctx, qtx, parent)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// pruneTombstones deletes chirp if it is a tombstone nothing refers to
+// anymore, then does the same for the chirp it replied to
+func pruneTombstones(ctx context.Context, qtx *database.Queries, chirp database.Chirp) error {
+	for chirp.DeletedAt.Valid && chirp.ReplyCount == 0 && chirp.QuoteCount == 0 {
+		err := qtx.DeleteChirp(ctx, chirp.ID)
+		if err != nil {
+			return err
+		}
+		if !chirp.InReplyTo.Valid {
+			return nil
+		}
+		chirp, err = qtx.DecrementReplyCount(ctx, chirp.InReplyTo.UUID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	errNotChirpOwner    = errors.New("not the chirp's owner")
+	errEditWindowClosed = errors.New("edit window has closed")
+	errRechirpNoBody    = errors.New("rechirps have no body")
+)
+
+// handlerEditChirp lets the owner of a chirp change its body for a while
+// after posting it. The old body is kept, see handlerGetChirpHistory.
+func (cfg *apiConfig) handlerEditChirp(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	caller := principalFromContext(r.Context())
+
+	// extract chirpID from URL
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	// decode JSON request body
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	// edits go through the same checks as new chirps
+	cleaned, err := cleanChirpBody(params.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	chirp, err := cfg.editChirp(r.Context(), chirpID, caller.UserID, cleaned)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		respondWithError(w, http.StatusNotFound, "Chirp not found", err)
+		return
+	case errors.Is(err, errNotChirpOwner):
+		// not even moderators can put words in someone else's mouth
+		respondWithError(w, http.StatusForbidden, "You can only edit your own chirps", nil)
+		return
+	case errors.Is(err, errRechirpNoBody):
+		respondWithError(w, http.StatusBadRequest, "Rechirps can't be edited", nil)
+		return
+	case errors.Is(err, errEditWindowClosed):
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("Chirps can only be edited within %s of posting", cfg.chirpEditWindow), nil)
+		return
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, "Couldn't edit chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chirpFromDB(chirp))
+}
+
+// editChirp stores the current body as a revision and replaces it, holding a
+// lock on the chirp so concurrent edits each keep the body they replaced
+func (cfg *apiConfig) editChirp(ctx context.Context, chirpID, userID uuid.UUID, body string) (database.Chirp, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	current, err := qtx.GetChirpForUpdate(ctx, chirpID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Missing or invalid token", err)
+		return database.Chirp{}, err
+	}
+	if current.DeletedAt.Valid {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+	if current.UserID.UUID != userID {
+		return database.Chirp{}, errNotChirpOwner
+	}
+	if current.Kind == chirpKindRechirp {
+		return database.Chirp{}, errRechirpNoBody
+	}
+	// nothing changed, so there is nothing worth a revision
+	if current.Body == body {
+		return current, nil
+	}
+
+	// the window is checked against the database clock, like token expiry
+	edited, err := qtx.UpdateChirpBody(ctx, database.UpdateChirpBodyParams{
+		Body:              body,
+		ID:                chirpID,
+		EditWindowSeconds: cfg.chirpEditWindow.Seconds(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.Chirp{}, errEditWindowClosed
+	}
+	if err != nil {
+		return database.Chirp{}, err
+	}
+
+	err = qtx.CreateChirpRevision(ctx, database.CreateChirpRevisionParams{
+		ChirpID: chirpID,
+		Body:    current.Body,
+	})
+	if err != nil {
+		return database.Chirp{}, err
+	}
+
+	return edited, tx.Commit()
+}
+
+func (cfg *apiConfig) handlerGetChirpHistory(w http.ResponseWriter, r *http.Request) {
+	type revision struct {
+		ID         uuid.UUID `json:"id"`
+		Body       string    `json:"body"`
+		ReplacedAt time.Time `json:"replaced_at"`
+	}
+	type response struct {
+		Chirp
+		Revisions []revision `json:"revisions"`
+	}
+
+	// extract chirpID from URL
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
 		return
 	}
-	if token == "" {
-		respondWithError(w, http.StatusUnauthorized, "Missing or invalid token", nil)
+
+	chirp, err := cfg.dbQueries.GetChirpByID(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't retrieve chirp", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.secretKey)
+
+	revisions, err := cfg.dbQueries.ListChirpRevisions(r.Context(), chirpID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirp history", err)
 		return
 	}
 
+	// oldest first, the chirp itself holds the current body
+	resp := response{
+		Chirp:     chirpFromDB(chirp),
+		Revisions: make([]revision, len(revisions)),
+	}
+	for i := range revisions {
+		resp.Revisions[i] = revision{
+			ID:         revisions[i].ID,
+			Body:       revisions[i].Body,
+			ReplacedAt: revisions[i].ReplacedAt,
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// replies deeper than this are left out of threads
+const maxThreadDepth = 50
+
+// handlerGetChirpThread returns a chirp with the chain of chirps it replies
+// to, root first, and a page of the replies below it. Replies come depth
+// first, each one followed by its own replies, oldest first at every level.
+func (cfg *apiConfig) handlerGetChirpThread(w http.ResponseWriter, r *http.Request) {
+	type reply struct {
+		Chirp
+		Depth int32 `json:"depth"`
+	}
+	type response struct {
+		Chirp      Chirp   `json:"chirp"`
+		Ancestors  []Chirp `json:"ancestors"`
+		Replies    []reply `json:"replies"`
+		NextCursor *string `json:"next_cursor"`
+	}
+
 	// extract chirpID from URL
-	chirpIDstring := r.PathValue("chirpID")
-	chirpID, err := uuid.Parse(chirpIDstring)
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
 		return
 	}
 
-	// get chirp to verify it exists
+	limit, cursor, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+	// one extra row tells us whether there is another page
+	params := database.ListChirpDescendantsParams{
+		ChirpID:  chirpID,
+		MaxDepth: maxThreadDepth,
+		MaxRows:  int32(limit + 1),
+	}
+	if cursor != "" {
+		path, err := decodeThreadCursor(cursor)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.AfterPath = path
+	}
+
 	chirp, err := cfg.dbQueries.GetChirpByID(r.Context(), chirpID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Chirp not found", err)
+		respondWithError(w, http.StatusNotFound, "Couldn't retrieve chirp", err)
 		return
 	}
 
-	// verify that the authenticated user is the owner of the chirp
-	if chirp.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You do not have permission to delete this chirp", nil)
+	ancestors, err := cfg.dbQueries.ListChirpAncestors(r.Context(), database.ListChirpAncestorsParams{
+		ChirpID:  chirpID,
+		MaxDepth: maxThreadDepth,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve thread", err)
 		return
 	}
 
-	err = cfg.dbQueries.DeleteChirp(r.Context(), chirpID)
+	descendants, err := cfg.dbQueries.ListChirpDescendants(r.Context(), params)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve thread", err)
 		return
 	}
 
-	// respond with no content
-	respondWithJSON(w, http.StatusNoContent, nil)
+	var nextCursor *string
+	if len(descendants) > limit {
+		descendants = descendants[:limit]
+		cursor := encodeThreadCursor(descendants[limit-1].Path)
+		nextCursor = &cursor
+	}
+
+	resp := response{
+		Chirp:      chirpFromDB(chirp),
+		Ancestors:  make([]Chirp, len(ancestors)),
+		Replies:    make([]reply, len(descendants)),
+		NextCursor: nextCursor,
+	}
+	for i := range ancestors {
+		resp.Ancestors[i] = chirpFromDB(database.Chirp(ancestors[i]))
+	}
+	for i, d := range descendants {
+		resp.Replies[i] = reply{
+			Chirp: chirpFromDB(database.Chirp{
+				ID:             d.ID,
+				CreatedAt:      d.CreatedAt,
+				UpdatedAt:      d.UpdatedAt,
+				Body:           d.Body,
+				UserID:         d.UserID,
+				EditedAt:       d.EditedAt,
+				InReplyTo:      d.InReplyTo,
+				ReplyCount:     d.ReplyCount,
+				DeletedAt:      d.DeletedAt,
+				Kind:           d.Kind,
+				OriginalID:     d.OriginalID,
+				RechirpCount:   d.RechirpCount,
+				QuoteCount:     d.QuoteCount,
+				ReactionCounts: d.ReactionCounts,
+			}),
+			Depth: d.Depth,
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// encodeThreadCursor packs the sort path of the last reply on a page, like
+// encodeChirpCursor it is opaque to clients
+func encodeThreadCursor(path []string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(path, ",")))
+}
+
+func decodeThreadCursor(cursor string) ([]string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("malformed cursor")
+	}
+	return strings.Split(string(raw), ","), nil
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/cryptidcodes/chirpy/internal/auth"
+)
+
+// browser sessions keep their tokens in cookies the frontend's JavaScript can't read.
+// The CSRF cookie is the exception, the frontend copies it into csrfHeader so
+// requests forged by other sites, which can't read it, are turned away.
+const (
+	accessCookie  = "chirpy_access"
+	refreshCookie = "chirpy_refresh"
+	csrfCookie    = "chirpy_csrf"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+var errInvalidCSRF = errors.New("missing or invalid CSRF token")
+
+// setSessionCookies stores a new access and refresh token in the browser.
+// The access cookie is Lax so a third-party app sending the user to
+// /oauth/authorize finds them logged in, the CSRF check still guards every
+// request that changes something. The refresh token is only ever needed by
+// the API's own frontend and stays Strict.
+func (cfg *apiConfig) setSessionCookies(w http.ResponseWriter, accessToken, refreshToken string) {
+	http.SetCookie(w, cfg.sessionCookie(accessCookie, accessToken, "/", int(accessTokenDuration.Seconds()), true, http.SameSiteLaxMode))
+	http.SetCookie(w, cfg.sessionCookie(refreshCookie, refreshToken, "/api", int(refreshTokenDuration.Seconds()), true, http.SameSiteStrictMode))
+}
+
+// setCSRFCookie gives a new browser session its CSRF token. Every login gets
+// a fresh one so a token planted before the login is never trusted.
+func (cfg *apiConfig) setCSRFCookie(w http.ResponseWriter) string {
+	csrfToken := auth.MakeRefreshToken()
+	// Lax like the access cookie, the consent page needs it to render its form
+	http.SetCookie(w, cfg.sessionCookie(csrfCookie, csrfToken, "/", int(refreshTokenDuration.Seconds()), false, http.SameSiteLaxMode))
+	return csrfToken
+}
+
+// clearSessionCookies logs the browser out
+func (cfg *apiConfig) clearSessionCookies(w http.ResponseWriter) {
+	http.SetCookie(w, cfg.sessionCookie(accessCookie, "", "/", -1, true, http.SameSiteLaxMode))
+	http.SetCookie(w, cfg.sessionCookie(refreshCookie, "", "/api", -1, true, http.SameSiteStrictMode))
+	http.SetCookie(w, cfg.sessionCookie(csrfCookie, "", "/", -1, false, http.SameSiteLaxMode))
+}
+
+func (cfg *apiConfig) sessionCookie(name, value, path string, maxAge int, httpOnly bool, sameSite http.SameSite) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		HttpOnly: httpOnly,
+		Secure:   cfg.cookieSecure,
+		SameSite: sameSite,
+	}
+}
+
+// validCSRF checks the double-submitted CSRF token, safe methods don't need one
+func validCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	// HTML forms, like the OAuth consent page, can't set headers and send it as a field
+	submitted := r.Header.Get(csrfHeader)
+	if submitted == "" {
+		submitted = r.PostFormValue("csrf_token")
+	}
+	return subtle.ConstantTimeCompare([]byte(submitted), []byte(c.Value)) == 1
+}
+
+// refreshTokenFromRequest reads the refresh token from the Authorization
+// header, or from the cookie for browser sessions. Requests using the cookie
+// must pass the CSRF check.
+func refreshTokenFromRequest(r *http.Request) (token string, fromCookie bool, err error) {
+	token, err = auth.GetBearerToken(r.Header)
+	if err == nil {
+		return token, false, nil
+	}
+	c, cookieErr := r.Cookie(refreshCookie)
+	if cookieErr != nil || c.Value == "" {
+		return "", false, err
+	}
+	if !validCSRF(r) {
+		return "", true, errInvalidCSRF
+	}
+	return c.Value, true, nil
+}
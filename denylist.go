@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// tokenDenylist holds the IDs of access tokens revoked before they expired.
+// Postgres is the source of truth so every instance of the server sees a
+// logout, the in-memory copy keeps lookups off the database on every request.
+type tokenDenylist struct {
+	db      *database.Queries
+	mu      sync.RWMutex
+	entries map[string]time.Time
+}
+
+func newTokenDenylist(db *database.Queries) *tokenDenylist {
+	return &tokenDenylist{
+		db:      db,
+		entries: map[string]time.Time{},
+	}
+}
+
+// Contains reports whether the token ID was revoked and hasn't expired yet
+func (d *tokenDenylist) Contains(jti string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	expiresAt, ok := d.entries[jti]
+	return ok && time.Now().Before(expiresAt)
+}
+
+// Revoke denylists a token until it would have expired anyway
+func (d *tokenDenylist) Revoke(ctx context.Context, jti string, userID uuid.UUID, expiresAt time.Time) error {
+	err := d.db.RevokeAccessToken(ctx, database.RevokeAccessTokenParams{
+		Jti:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.entries[jti] = expiresAt
+	d.mu.Unlock()
+	return nil
+}
+
+// sync copies tokens revoked by other instances into memory and forgets
+// expired ones. It reads every unexpired row each time rather than only the
+// new ones: sequence numbers are handed out when a row is inserted, not when
+// it commits, so a revocation can become visible after rows with higher
+// numbers and would be skipped for good. The table only ever holds tokens
+// that haven't expired, which keeps the full read small.
+func (d *tokenDenylist) sync(ctx context.Context) error {
+	rows, err := d.db.ListRevokedAccessTokens(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// merged rather than replaced, a Revoke that lands while the rows are
+	// read must not be dropped until the next sync
+	for _, row := range rows {
+		d.entries[row.Jti] = row.ExpiresAt
+	}
+	for jti, expiresAt := range d.entries {
+		if !now.Before(expiresAt) {
+			delete(d.entries, jti)
+		}
+	}
+	return nil
+}
+
+// run keeps the denylist in sync and deletes expired rows, it never returns
+func (d *tokenDenylist) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := d.sync(ctx)
+		if err != nil {
+			log.Printf("Error syncing token denylist: %s", err)
+		}
+		_, err = d.db.DeleteExpiredRevokedAccessTokens(ctx)
+		if err != nil {
+			log.Printf("Error deleting expired revoked tokens: %s", err)
+		}
+		cancel()
+	}
+}
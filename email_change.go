@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cryptidcodes/chirpy/internal/auth"
+	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/cryptidcodes/chirpy/internal/mailer"
+	"github.com/lib/pq"
+)
+
+// sendEmailChangeEmails mails a confirmation link to the user's pending
+// address and a notice to their current one, so the owner hears about the
+// change even if it wasn't them asking for it
+func (cfg *apiConfig) sendEmailChangeEmails(ctx context.Context, user database.User) error {
+	newEmail := user.PendingEmail.String
+	token, err := auth.MakePurposeJWT(user.ID, auth.PurposeChangeEmail, strings.ToLower(newEmail), cfg.jwtKeys, emailVerificationDuration)
+	if err != nil {
+		return err
+	}
+
+	link := cfg.appURL + "/confirm-email?token=" + url.QueryEscape(token)
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new Chirpy email address",
+		Body: fmt.Sprintf("Someone asked to use this address for their Chirpy account.\n\n"+
+			"Follow this link to confirm the change:\n\n%s\n\n"+
+			"The link expires in 24 hours. Until then the account keeps its old address. If you didn't ask for this, you can ignore this email.\n", link),
+	})
+	if err != nil {
+		return err
+	}
+
+	// the notice is best effort, the old address may not even work anymore
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy email address is about to change",
+		Body: fmt.Sprintf("Someone asked to change the email address of your Chirpy account to %s.\n\n"+
+			"Nothing changes until the link sent to the new address is followed. "+
+			"If this wasn't you, reset your password right away to stop the change.\n", newEmail),
+	})
+	if err != nil {
+		log.Printf("Error sending email change notice: %s", err)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handlerConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+	type response struct {
+		User
+	}
+
+	// decode JSON request body
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	confirmation, err := auth.ValidatePurposeJWT(params.Token, auth.PurposeChangeEmail, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired confirmation token", err)
+		return
+	}
+
+	// the link only works for the change that is still pending, a newer request replaces it
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), confirmation.UserID)
+	if err != nil || !user.PendingEmail.Valid || !confirmation.IsBoundTo(strings.ToLower(user.PendingEmail.String)) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired confirmation token", err)
+		return
+	}
+
+	oldEmail := user.Email
+	changed, err := cfg.dbQueries.ConfirmPendingEmail(r.Context(), database.ConfirmPendingEmailParams{
+		ID:           user.ID,
+		PendingEmail: user.PendingEmail,
+	})
+	if isUniqueViolation(err) {
+		respondWithError(w, http.StatusConflict, "Email address is already in use", err)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired confirmation token", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't change email", err)
+		return
+	}
+	cfg.recordOwnerEvent(r, changed.ID, eventEmailChanged, oldEmail+" -> "+changed.Email)
+
+	// reset links already mailed to the old address shouldn't outlive the change
+	err = cfg.dbQueries.InvalidatePasswordResetTokens(r.Context(), changed.ID)
+	if err != nil {
+		log.Printf("Error invalidating password reset tokens: %s", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		User: User{
+			ID:            changed.ID,
+			CreatedAt:     changed.CreatedAt,
+			UpdatedAt:     changed.UpdatedAt,
+			Email:         changed.Email,
+			IsChirpyRed:   changed.IsChirpyRed,
+			EmailVerified: changed.EmailVerified,
+			Role:          changed.Role,
+		},
+	})
+}
+
+// isUniqueViolation reports whether err is postgres rejecting a duplicate value
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+)
+
+// envInt reads an optional integer setting, falling back to def when it is unset
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer: %s", name, err)
+	}
+	return n
+}
+
+// envDuration reads an optional duration setting like "15m", falling back to def when it is unset
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s must be a duration: %s", name, err)
+	}
+	return d
+}
+
+// envBool reads an optional true/false setting, falling back to def when it is unset
+func envBool(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s must be true or false: %s", name, err)
+	}
+	return b
+}
+
+// hashParamsFromEnv reads the argon2 settings. They are checked here because
+// argon2 panics on zero iterations or parallelism, which would take down
+// every login and signup instead of the server refusing to start.
+func hashParamsFromEnv() (*argon2id.Params, error) {
+	memory := envInt("ARGON2_MEMORY_KIB", int(argon2id.DefaultParams.Memory))
+	iterations := envInt("ARGON2_ITERATIONS", int(argon2id.DefaultParams.Iterations))
+	parallelism := envInt("ARGON2_PARALLELISM", int(argon2id.DefaultParams.Parallelism))
+
+	if parallelism < 1 || parallelism > math.MaxUint8 {
+		return nil, fmt.Errorf("ARGON2_PARALLELISM must be between 1 and %d", math.MaxUint8)
+	}
+	if iterations < 1 || iterations > math.MaxUint32 {
+		return nil, fmt.Errorf("ARGON2_ITERATIONS must be between 1 and %d", uint32(math.MaxUint32))
+	}
+	// argon2 needs at least 8 KiB per lane
+	if memory < 8*parallelism || memory > math.MaxUint32 {
+		return nil, fmt.Errorf("ARGON2_MEMORY_KIB must be between %d (8 per lane) and %d", 8*parallelism, uint32(math.MaxUint32))
+	}
+
+	return &argon2id.Params{
+		Memory:      uint32(memory),
+		Iterations:  uint32(iterations),
+		Parallelism: uint8(parallelism),
+		SaltLength:  argon2id.DefaultParams.SaltLength,
+		KeyLength:   argon2id.DefaultParams.KeyLength,
+	}, nil
+}
@@ -2,96 +2,204 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/alexedwards/argon2id"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
-func HashPassword(password string) (string, error) {
-	// hash the password using argon2id.CreateHash
-	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
-	if err != nil {
-		return "", err
-	}
-	return hash, nil
+// purpose tokens are short-lived JWTs that are only accepted by the step they were made for
+const (
+	// PurposeMFAChallenge tokens prove the password was correct while the second factor is still missing
+	PurposeMFAChallenge = "chirpy-mfa-challenge"
+	// PurposeVerifyEmail tokens are mailed to a new address to prove the user can read it
+	PurposeVerifyEmail = "chirpy-verify-email"
+	// PurposeChangeEmail tokens are mailed to the address a user wants to switch to
+	PurposeChangeEmail = "chirpy-change-email"
+	// PurposeMagicLink tokens are mailed to log a user in without their password
+	PurposeMagicLink = "chirpy-magic-link"
+	// PurposeRestoreAccount tokens are mailed when an account is deleted, to undo it during the grace period
+	PurposeRestoreAccount = "chirpy-restore-account"
+)
+
+type claims struct {
+	jwt.RegisteredClaims
+	// Binding is a hash of a value the token is only valid together with, like an email address
+	Binding string `json:"bnd,omitempty"`
+	// Scope and ClientID are only set on tokens issued to third-party clients
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
 }
 
-func CheckPasswordHash(password, hash string) (bool, error) {
-	// check the password against the hash
-	match, err := argon2id.ComparePasswordAndHash(password, hash)
-	if err != nil {
-		return false, err
+func MakeJWT(userID uuid.UUID, keys *KeySet, expiresIn time.Duration) (string, error) {
+	return makeJWT(userID, "", "", keys, expiresIn)
+}
+
+// MakePurposeJWT creates a token that only ValidatePurposeJWT with the same
+// purpose accepts. If binding is not empty, callers can check it with IsBoundTo.
+func MakePurposeJWT(userID uuid.UUID, purpose, binding string, keys *KeySet, expiresIn time.Duration) (string, error) {
+	return makeJWT(userID, purpose, binding, keys, expiresIn)
+}
+
+func makeJWT(userID uuid.UUID, audience, binding string, keys *KeySet, expiresIn time.Duration) (string, error) {
+	c := newClaims(userID, expiresIn)
+	if audience != "" {
+		c.Audience = jwt.ClaimStrings{audience}
+	}
+	if binding != "" {
+		c.Binding = HashToken(binding)
 	}
-	return match, nil
+	return signClaims(c, keys)
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+// MakeScopedJWT creates an access token for a third-party client that only
+// grants the given scopes
+func MakeScopedJWT(userID uuid.UUID, clientID string, scopes []string, keys *KeySet, expiresIn time.Duration) (string, error) {
+	c := newClaims(userID, expiresIn)
+	c.ClientID = clientID
+	c.Scope = strings.Join(scopes, " ")
+	return signClaims(c, keys)
+}
+
+func newClaims(userID uuid.UUID, expiresIn time.Duration) claims {
 	now := time.Now()
+	return claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   userID.String(),
+			// every token gets its own ID so it can be revoked on its own
+			ID: uuid.NewString(),
+		},
+	}
+}
 
+func signClaims(c claims, keys *KeySet) (string, error) {
 	// create a new token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
-		Subject:   userID.String(),
-	})
-	// sign the token with the secret key
-	return token.SignedString([]byte(tokenSecret))
-
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, c)
+	// sign the token with the current signing key
+	return keys.sign(token)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claims := &jwt.RegisteredClaims{}
+// AccessToken is a validated access JWT
+type AccessToken struct {
+	UserID uuid.UUID
+	// ID is the token's jti, used to revoke it before it expires
+	ID        string
+	ExpiresAt time.Time
+	// ClientID is the third-party client the token was issued to, if any.
+	// Scopes is nil for tokens issued to the user directly, which can do
+	// anything the user can, and limits what a client's tokens can do.
+	ClientID string
+	Scopes   []string
+}
 
-	// parse the token with the claims
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		claims,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
-	)
+// ValidateJWT checks an access token's signature and expiry. It doesn't know
+// about revoked tokens, callers have to check the ID against their denylist.
+func ValidateJWT(tokenString string, keys *KeySet) (AccessToken, error) {
+	c, err := validateJWT(tokenString, "", keys)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	id, err := parseSubject(c)
 	if err != nil {
-		return uuid.Nil, err
+		return AccessToken{}, err
+	}
+	if c.ID == "" || c.ExpiresAt == nil {
+		return AccessToken{}, fmt.Errorf("token has no ID or expiry")
+	}
+	token := AccessToken{UserID: id, ID: c.ID, ExpiresAt: c.ExpiresAt.Time}
+	if c.ClientID != "" {
+		token.ClientID = c.ClientID
+		token.Scopes = append([]string{}, strings.Fields(c.Scope)...)
 	}
+	return token, nil
+}
+
+// PurposeToken is a validated purpose JWT
+type PurposeToken struct {
+	UserID  uuid.UUID
+	binding string
+}
+
+// IsBoundTo reports whether the token was created with this binding value
+func (t PurposeToken) IsBoundTo(value string) bool {
+	return t.binding != "" && subtle.ConstantTimeCompare([]byte(t.binding), []byte(HashToken(value))) == 1
+}
 
-	userIDString, err := token.Claims.GetSubject()
+func ValidatePurposeJWT(tokenString, purpose string, keys *KeySet) (PurposeToken, error) {
+	c, err := validateJWT(tokenString, purpose, keys)
 	if err != nil {
-		return uuid.Nil, err
+		return PurposeToken{}, err
 	}
+	id, err := parseSubject(c)
+	if err != nil {
+		return PurposeToken{}, err
+	}
+	return PurposeToken{UserID: id, binding: c.Binding}, nil
+}
+
+func validateJWT(tokenString, audience string, keys *KeySet) (*claims, error) {
+	c := &claims{}
 
-	issuer, err := token.Claims.GetIssuer()
+	// parse the token with the claims, verifying it with the key named in its header
+	options := []jwt.ParserOption{jwt.WithValidMethods(keys.validMethods())}
+	if audience != "" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		c,
+		keys.keyFunc,
+		options...,
+	)
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
 	}
 
-	if issuer != "chirpy" {
-		return uuid.Nil, fmt.Errorf("invalid issuer")
+	// access tokens never have an audience, so purpose tokens can't be used as one
+	if audience == "" && len(c.Audience) > 0 {
+		return nil, fmt.Errorf("token is not an access token")
 	}
 
-	id, err := uuid.Parse(userIDString)
+	if c.Issuer != "chirpy" {
+		return nil, fmt.Errorf("invalid issuer")
+	}
+
+	return c, nil
+}
+
+func parseSubject(c *claims) (uuid.UUID, error) {
+	id, err := uuid.Parse(c.Subject)
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("invalid user ID: %v", err)
 	}
-
 	return id, nil
 }
 
-func GetBearerToken(headers http.Header) (string, error) {
+// GetAuthorization returns the credentials from an "Authorization: <scheme> <credentials>" header
+func GetAuthorization(headers http.Header, scheme string) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
 		return "", fmt.Errorf("auth header required")
 	}
 
-	token, hasPrefix := strings.CutPrefix(authHeader, "Bearer ")
+	credentials, hasPrefix := strings.CutPrefix(authHeader, scheme+" ")
 	if !hasPrefix {
 		return "", fmt.Errorf("invalid authorization header")
 	}
-	return token, nil
+	return credentials, nil
+}
+
+func GetBearerToken(headers http.Header) (string, error) {
+	return GetAuthorization(headers, "Bearer")
 }
 
 func MakeRefreshToken() string {
@@ -100,14 +208,13 @@ func MakeRefreshToken() string {
 	return hex.EncodeToString(key)
 }
 
+// HashToken returns the hex SHA-256 of a high-entropy token so it can be stored and looked up
+// without keeping the token itself. Passwords must use a Hasher instead.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
-	apiKey := headers.Get("Authorization")
-	if apiKey == "" {
-		return "", fmt.Errorf("api key required")
-	}
-	key, hasPrefix := strings.CutPrefix(apiKey, "ApiKey ")
-	if !hasPrefix {
-		return "", fmt.Errorf("invalid api key header")
-	}
-	return key, nil
+	return GetAuthorization(headers, "ApiKey")
 }
@@ -0,0 +1,15 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// invite codes carry a prefix too, they are shorter since people pass them around by hand
+const inviteCodePrefix = "chirpy_inv_"
+
+func MakeInviteCode() string {
+	key := make([]byte, 16)
+	rand.Read(key)
+	return inviteCodePrefix + hex.EncodeToString(key)
+}
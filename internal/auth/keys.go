@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is a single key that JWTs can be signed and verified with
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Private is nil for retired keys that are only kept to verify tokens
+	Private interface{}
+	// Public is nil for symmetric keys, which are never published
+	Public interface{}
+}
+
+// KeySet holds every key a token may be verified with and the key new tokens are signed with
+type KeySet struct {
+	signing *SigningKey
+	keys    map[string]*SigningKey
+}
+
+// NewHMACKeySet returns a key set that signs and verifies HS256 tokens with a shared secret
+func NewHMACKeySet(secret string) *KeySet {
+	key := &SigningKey{
+		ID:      "default",
+		Method:  jwt.SigningMethodHS256,
+		Private: []byte(secret),
+	}
+	return &KeySet{
+		signing: key,
+		keys:    map[string]*SigningKey{key.ID: key},
+	}
+}
+
+// LoadKeySet reads every <kid>.pem file in dir. Private keys (RSA or Ed25519)
+// can sign and verify, public keys can only verify. New tokens are signed
+// with signingKeyID, which may be empty if dir holds exactly one private key.
+func LoadKeySet(dir, signingKeyID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	ks := &KeySet{keys: map[string]*SigningKey{}}
+	privateKeys := []*SigningKey{}
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := loadPEMKey(path, kid)
+		if err != nil {
+			return nil, err
+		}
+		ks.keys[kid] = key
+		if key.Private != nil {
+			privateKeys = append(privateKeys, key)
+		}
+	}
+
+	if signingKeyID == "" {
+		if len(privateKeys) != 1 {
+			return nil, fmt.Errorf("found %d private keys in %s, the signing key ID must be set", len(privateKeys), dir)
+		}
+		ks.signing = privateKeys[0]
+		return ks, nil
+	}
+
+	signing, ok := ks.keys[signingKeyID]
+	if !ok || signing.Private == nil {
+		return nil, fmt.Errorf("no private key with ID %q in %s", signingKeyID, dir)
+	}
+	ks.signing = signing
+	return ks, nil
+}
+
+func loadPEMKey(path, kid string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Private: k, Public: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Public: k}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, Private: k, Public: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, Public: k}, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported key type %T", path, parsed)
+}
+
+// sign signs the token with the current signing key and stamps its kid header
+func (ks *KeySet) sign(token *jwt.Token) (string, error) {
+	token.Method = ks.signing.Method
+	token.Header["alg"] = ks.signing.Method.Alg()
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signing.Private)
+}
+
+// keyFunc picks the verification key named by the token's kid header
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		// tokens signed before key IDs existed carry no kid
+		if kid != "" || ks.signing.Public != nil {
+			return nil, fmt.Errorf("unknown key ID %q", kid)
+		}
+		key = ks.signing
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), key.ID)
+	}
+	if key.Public == nil {
+		return key.Private, nil
+	}
+	return key.Public, nil
+}
+
+// validMethods lists the algorithms of every key in the set
+func (ks *KeySet) validMethods() []string {
+	methods := []string{}
+	seen := map[string]bool{}
+	for _, key := range ks.keys {
+		alg := key.Method.Alg()
+		if !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every asymmetric key in the set
+func (ks *KeySet) JWKS() JWKS {
+	kids := make([]string, 0, len(ks.keys))
+	for kid := range ks.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	jwks := JWKS{Keys: []JWK{}}
+	for _, kid := range kids {
+		key := ks.keys[kid]
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "OKP",
+				Kid: kid,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return jwks
+}
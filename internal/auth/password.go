@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+)
+
+// ErrHasherBusy is returned when too many passwords are already being hashed
+var ErrHasherBusy = errors.New("too many password hashes in progress")
+
+// Hasher hashes and checks passwords with argon2id. Every hash takes a lot of
+// memory, so only a limited number run at once and callers that can't get a
+// slot in time get ErrHasherBusy instead of piling up.
+type Hasher struct {
+	params  *argon2id.Params
+	slots   chan struct{}
+	maxWait time.Duration
+}
+
+// NewHasher creates a Hasher that hashes new passwords with params and runs
+// at most maxConcurrent hashes at once, waiting up to maxWait for a free slot
+func NewHasher(params *argon2id.Params, maxConcurrent int, maxWait time.Duration) *Hasher {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &Hasher{
+		params:  params,
+		slots:   make(chan struct{}, maxConcurrent),
+		maxWait: maxWait,
+	}
+}
+
+func (h *Hasher) acquire(ctx context.Context) error {
+	timer := time.NewTimer(h.maxWait)
+	defer timer.Stop()
+
+	select {
+	case h.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrHasherBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *Hasher) release() {
+	<-h.slots
+}
+
+// HashPassword hashes password with the current parameters
+func (h *Hasher) HashPassword(ctx context.Context, password string) (string, error) {
+	err := h.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer h.release()
+
+	return argon2id.CreateHash(password, h.params)
+}
+
+// CheckPasswordHash reports whether password matches hash. needsRehash is set
+// when the hash was made with different parameters than the current ones, so
+// callers can store a fresh hash while they still have the plain password.
+func (h *Hasher) CheckPasswordHash(ctx context.Context, password, hash string) (match, needsRehash bool, err error) {
+	err = h.acquire(ctx)
+	if err != nil {
+		return false, false, err
+	}
+	defer h.release()
+
+	match, params, err := argon2id.CheckHash(password, hash)
+	if err != nil || !match {
+		return false, false, err
+	}
+	return true, !h.sameParams(params), nil
+}
+
+func (h *Hasher) sameParams(params *argon2id.Params) bool {
+	return params.Memory == h.params.Memory &&
+		params.Iterations == h.params.Iterations &&
+		params.Parallelism == h.params.Parallelism &&
+		params.KeyLength == h.params.KeyLength &&
+		params.SaltLength == h.params.SaltLength
+}
@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+// PKCEMethodS256 is the only PKCE method accepted, "plain" gives no protection
+// against a code intercepted on its way back to the client
+const PKCEMethodS256 = "S256"
+
+// VerifyPKCE checks a code verifier against the S256 challenge sent when the
+// authorization code was requested (RFC 7636)
+func VerifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 || challenge == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// password policy rules, reported back to clients so they can tell the user what to fix
+const (
+	RuleMinLength    = "min_length"
+	RuleMaxLength    = "max_length"
+	RuleEmailDerived = "email_derived"
+	RuleBreached     = "breached"
+)
+
+// PolicyError explains which rule a password broke
+type PolicyError struct {
+	Rule    string
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+// PasswordPolicy decides which passwords users are allowed to pick
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	// Breached holds known breached passwords, it may be nil
+	Breached *BreachedPasswords
+}
+
+// Check returns a *PolicyError if password breaks a rule. Lengths are counted
+// in characters rather than bytes.
+func (p *PasswordPolicy) Check(password, email string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return &PolicyError{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength),
+		}
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return &PolicyError{
+			Rule:    RuleMaxLength,
+			Message: fmt.Sprintf("Password must be at most %d characters long", p.MaxLength),
+		}
+	}
+	if emailDerived(password, email) {
+		return &PolicyError{
+			Rule:    RuleEmailDerived,
+			Message: "Password must not contain your email address",
+		}
+	}
+	if p.Breached.Contains(password) {
+		return &PolicyError{
+			Rule:    RuleBreached,
+			Message: "Password has appeared in a data breach, choose a different one",
+		}
+	}
+	return nil
+}
+
+// emailDerived catches passwords built from the email address or its local
+// part, like "alice@example.com" or "alice2024". Very short local parts are
+// ignored since they would rule out too many unrelated passwords.
+func emailDerived(password, email string) bool {
+	password = strings.ToLower(password)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return false
+	}
+	if strings.Contains(password, email) {
+		return true
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return len(local) >= 4 && strings.Contains(password, local)
+}
+
+// BreachedPasswords is a set of SHA-1 hashes of breached passwords, grouped
+// by the first five hex characters like the k-anonymity range API of Have I
+// Been Pwned, so a lookup only scans the hashes sharing a prefix.
+type BreachedPasswords struct {
+	ranges map[string]map[string]struct{}
+}
+
+// LoadBreachedPasswords reads a file with one uppercase or lowercase SHA-1
+// hash per line, optionally followed by ":<count>" as in the Pwned Passwords
+// downloads. Blank lines and lines starting with # are skipped.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := &BreachedPasswords{ranges: map[string]map[string]struct{}{}}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(line, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("%s:%d: not a SHA-1 hash", path, lineNo)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("%s:%d: not a SHA-1 hash", path, lineNo)
+		}
+
+		prefix, suffix := hash[:5], hash[5:]
+		if b.ranges[prefix] == nil {
+			b.ranges[prefix] = map[string]struct{}{}
+		}
+		b.ranges[prefix][suffix] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Contains reports whether password is in the list. A nil list contains nothing.
+func (b *BreachedPasswords) Contains(password string) bool {
+	if b == nil {
+		return false
+	}
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	_, ok := b.ranges[hash[:5]][hash[5:]]
+	return ok
+}
+
+// Len returns the number of hashes in the list
+func (b *BreachedPasswords) Len() int {
+	if b == nil {
+		return 0
+	}
+	n := 0
+	for _, suffixes := range b.ranges {
+		n += len(suffixes)
+	}
+	return n
+}
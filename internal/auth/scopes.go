@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// scopes limit what personal access tokens and third-party clients can do.
+// Reading chirps needs no token at all, so there is no scope for it.
+const (
+	ScopeChirpsWrite = "chirps:write"
+)
+
+var knownScopes = map[string]bool{
+	ScopeChirpsWrite: true,
+}
+
+func ValidScope(scope string) bool {
+	return knownScopes[scope]
+}
+
+// HasScope reports whether scope is among the granted scopes
+func HasScope(granted []string, scope string) bool {
+	for _, s := range granted {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// personal access tokens carry a prefix so they can be told apart from JWTs
+// and spotted by secret scanners
+const personalAccessTokenPrefix = "chirpy_pat_"
+
+func MakePersonalAccessToken() string {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return personalAccessTokenPrefix + hex.EncodeToString(key)
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, personalAccessTokenPrefix)
+}
+
+// OAuth client secrets carry a prefix for the same reason
+const clientSecretPrefix = "chirpy_cs_"
+
+func MakeClientSecret() string {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return clientSecretPrefix + hex.EncodeToString(key)
+}
@@ -7,29 +7,114 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to, kind, original_id)
 VALUES (
     DEFAULT,
     DEFAULT,
     DEFAULT,
     $1,
-    $2
+    $2,
+    $3,
+    $4,
+    $5
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, edited_at, in_reply_to, reply_count, deleted_at, kind, original_id, rechirp_count, quote_count, reaction_counts
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body       string
+	UserID     uuid.NullUUID
+	InReplyTo  uuid.NullUUID
+	Kind       string
+	OriginalID uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.Body,
+		arg.UserID,
+		arg.InReplyTo,
+		arg.Kind,
+		arg.OriginalID,
+	)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.EditedAt,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.Kind,
+		&i.OriginalID,
+		&i.RechirpCount,
+		&i.QuoteCount,
+		&i.ReactionCounts,
+	)
+	return i, err
+}
+
+const decrementQuoteCount = `-- name: DecrementQuoteCount :one
+UPDATE chirps SET quote_count = quote_count - 1
+WHERE id = $1
+AND quote_count > 0
+RETURNING id, created_at, updated_at, body, user_id, edited_at, in_reply_to, reply_count, deleted_at, kind, original_id, rechirp_count, quote_count, reaction_counts
+`
+
+func (q *Queries) DecrementQuoteCount(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, decrementQuoteCount, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.EditedAt,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.Kind,
+		&i.OriginalID,
+		&i.RechirpCount,
+		&i.QuoteCount,
+		&i.ReactionCounts,
+	)
+	return i, err
+}
+
+const decrementRechirpCount = `-- name: DecrementRechirpCount :exec
+UPDATE chirps SET rechirp_count = rechirp_count - 1
+WHERE id = $1
+AND rechirp_count > 0
+`
+
+func (q *Queries) DecrementRechirpCount(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, decrementRechirpCount, id)
+	return err
+}
+
+const decrementReplyCount = `-- name: DecrementReplyCount :one
+UPDATE chirps SET reply_count = reply_count - 1
+WHERE id = $1
+AND reply_count > 0
+RETURNING id, created_at, updated_at, body, user_id, edited_at, in_reply_to, reply_count, deleted_at, kind, original_id, rechirp_count, quote_count, reaction_counts
+`
+
+func (q *Queries) DecrementReplyCount(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, decrementReplyCount, id)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,6 +122,15 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.EditedAt,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.Kind,
+		&i.OriginalID,
+		&i.RechirpCount,
+		&i.QuoteCount,
+		&i.ReactionCounts,
 	)
 	return i, err
 }
@@ -51,13 +145,62 @@ func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
-const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-ORDER BY created_at ASC
+const deleteRechirp = `-- name: DeleteRechirp :one
+DELETE FROM chirps
+WHERE user_id = $1
+AND original_id = $2
+AND kind = 'rechirp'
+RETURNING id, created_at, updated_at, body, user_id, edited_at, in_reply_to, reply_count, deleted_at, kind, original_id, rechirp_count, quote_count, reaction_counts
+`
+
+type DeleteRechirpParams struct {
+	UserID     uuid.NullUUID
+	OriginalID uuid.NullUUID
+}
+
+func (q *Queries) DeleteRechirp(ctx context.Context, arg DeleteRechirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, deleteRechirp, arg.UserID, arg.OriginalID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.EditedAt,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.Kind,
+		&i.OriginalID,
+		&i.RechirpCount,
+		&i.QuoteCount,
+		&i.ReactionCounts,
+	)
+	return i, err
+}
+
+const deleteRechirpsOf = `-- name: DeleteRechirpsOf :exec
+DELETE FROM chirps
+WHERE original_id = $1
+AND kind = 'rechirp'
 `
 
-func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirps)
+func (q *Queries) DeleteRechirpsOf(ctx context.Context, originalID uuid.NullUUID) error {
+	_, err := q.db.ExecContext(ctx, deleteRechirpsOf, originalID)
+	return err
+}
+
+const getAllChirpsByUser = `-- name: GetAllChirpsByUser :many
+SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.edited_at, c.in_reply_to, c.reply_count, c.deleted_at, c.kind, c.original_id, c.rechirp_count, c.quote_count, c.reaction_counts FROM chirps c
+JOIN users u ON u.id = c.user_id
+WHERE c.user_id = $1
+AND c.deleted_at IS NULL
+AND u.deleted_at IS NULL
+`
+
+func (q *Queries) GetAllChirpsByUser(ctx context.Context, userID uuid.NullUUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getAllChirpsByUser, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -71,6 +214,15 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.EditedAt,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.Kind,
+			&i.OriginalID,
+			&i.RechirpCount,
+			&i.QuoteCount,
+			&i.ReactionCounts,
 		); err != nil {
 			return nil, err
 		}
@@ -85,13 +237,343 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getAllChirpsByUser = `-- name: GetAllChirpsByUser :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+const getChirpByID = `-- name: GetChirpByID :one
+SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.edited_at, c.in_reply_to, c.reply_count, c.deleted_at, c.kind, c.original_id, c.rechirp_count, c.quote_count, c.reaction_counts FROM chirps c
+JOIN users u ON u.id = c.user_id
+WHERE c.id = $1
+AND u.deleted_at IS NULL
+`
+
+func (q *Queries) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpByID, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.EditedAt,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.Kind,
+		&i.OriginalID,
+		&i.RechirpCount,
+		&i.QuoteCount,
+		&i.ReactionCounts,
+	)
+	return i, err
+}
+
+const getChirpForUpdate = `-- name: GetChirpForUpdate :one
+SELECT id, created_at, updated_at, body, user_id, edited_at, in_reply_to, reply_count, deleted_at, kind, original_id, rechirp_count, quote_count, reaction_counts FROM chirps
+WHERE id = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetChirpForUpdate(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpForUpdate, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.EditedAt,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.Kind,
+		&i.OriginalID,
+		&i.RechirpCount,
+		&i.QuoteCount,
+		&i.ReactionCounts,
+	)
+	return i, err
+}
+
+const incrementQuoteCount = `-- name: IncrementQuoteCount :execrows
+UPDATE chirps SET quote_count = quote_count + 1
+WHERE id = $1
+AND deleted_at IS NULL
+AND kind <> 'rechirp'
+AND EXISTS (SELECT 1 FROM users u WHERE u.id = chirps.user_id AND u.deleted_at IS NULL)
+`
+
+func (q *Queries) IncrementQuoteCount(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, incrementQuoteCount, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const incrementRechirpCount = `-- name: IncrementRechirpCount :execrows
+UPDATE chirps SET rechirp_count = rechirp_count + 1
+WHERE id = $1
+AND deleted_at IS NULL
+AND kind <> 'rechirp'
+AND EXISTS (SELECT 1 FROM users u WHERE u.id = chirps.user_id AND u.deleted_at IS NULL)
+`
+
+func (q *Queries) IncrementRechirpCount(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, incrementRechirpCount, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const incrementReplyCount = `-- name: IncrementReplyCount :execrows
+UPDATE chirps SET reply_count = reply_count + 1
+WHERE id = $1
+AND deleted_at IS NULL
+AND kind <> 'rechirp'
+AND EXISTS (SELECT 1 FROM users u WHERE u.id = chirps.user_id AND u.deleted_at IS NULL)
+`
+
+func (q *Queries) IncrementReplyCount(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, incrementReplyCount, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const listChirpAncestors = `-- name: ListChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT c.*, 1 AS depth
+    FROM chirps c
+    WHERE c.id = (SELECT r.in_reply_to FROM chirps r WHERE r.id = $1)
+    UNION ALL
+    SELECT c.*, a.depth + 1
+    FROM chirps c
+    JOIN ancestors a ON c.id = a.in_reply_to
+    WHERE a.depth < $2
+)
+SELECT a.id, a.created_at, a.updated_at, a.body, a.user_id, a.edited_at, a.in_reply_to, a.reply_count,
+    COALESCE(a.deleted_at, u.deleted_at) AS deleted_at, a.kind, a.original_id, a.rechirp_count, a.quote_count, a.reaction_counts
+FROM ancestors a
+LEFT JOIN users u ON u.id = a.user_id
+ORDER BY a.depth DESC
+`
+
+type ListChirpAncestorsParams struct {
+	ChirpID  uuid.UUID
+	MaxDepth int32
+}
+
+type ListChirpAncestorsRow struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Body           string
+	UserID         uuid.NullUUID
+	EditedAt       sql.NullTime
+	InReplyTo      uuid.NullUUID
+	ReplyCount     int32
+	DeletedAt      sql.NullTime
+	Kind           string
+	OriginalID     uuid.NullUUID
+	RechirpCount   int32
+	QuoteCount     int32
+	ReactionCounts json.RawMessage
+}
+
+func (q *Queries) ListChirpAncestors(ctx context.Context, arg ListChirpAncestorsParams) ([]ListChirpAncestorsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpAncestors, arg.ChirpID, arg.MaxDepth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListChirpAncestorsRow
+	for rows.Next() {
+		var i ListChirpAncestorsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.EditedAt,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.Kind,
+			&i.OriginalID,
+			&i.RechirpCount,
+			&i.QuoteCount,
+			&i.ReactionCounts,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpDescendants = `-- name: ListChirpDescendants :many
+WITH RECURSIVE descendants AS (
+    SELECT c.*, 1 AS depth, ARRAY[to_char(c.created_at, 'YYYYMMDDHH24MISSUS') || c.id::text] AS path
+    FROM chirps c
+    WHERE c.in_reply_to = $1
+    UNION ALL
+    SELECT c.*, d.depth + 1, d.path || (to_char(c.created_at, 'YYYYMMDDHH24MISSUS') || c.id::text)
+    FROM chirps c
+    JOIN descendants d ON c.in_reply_to = d.id
+    WHERE d.depth < $2
+)
+SELECT d.id, d.created_at, d.updated_at, d.body, d.user_id, d.edited_at, d.in_reply_to, d.reply_count,
+    COALESCE(d.deleted_at, u.deleted_at) AS deleted_at, d.kind, d.original_id, d.rechirp_count, d.quote_count, d.reaction_counts,
+    d.depth::integer AS depth,
+    d.path::text[] AS path
+FROM descendants d
+LEFT JOIN users u ON u.id = d.user_id
+WHERE ($3::text[] IS NULL OR d.path > $3::text[])
+ORDER BY d.path
+LIMIT $4
+`
+
+type ListChirpDescendantsParams struct {
+	ChirpID   uuid.UUID
+	MaxDepth  int32
+	AfterPath []string
+	MaxRows   int32
+}
+
+type ListChirpDescendantsRow struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Body           string
+	UserID         uuid.NullUUID
+	EditedAt       sql.NullTime
+	InReplyTo      uuid.NullUUID
+	ReplyCount     int32
+	DeletedAt      sql.NullTime
+	Kind           string
+	OriginalID     uuid.NullUUID
+	RechirpCount   int32
+	QuoteCount     int32
+	ReactionCounts json.RawMessage
+	Depth          int32
+	Path           []string
+}
+
+func (q *Queries) ListChirpDescendants(ctx context.Context, arg ListChirpDescendantsParams) ([]ListChirpDescendantsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpDescendants,
+		arg.ChirpID,
+		arg.MaxDepth,
+		pq.Array(arg.AfterPath),
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListChirpDescendantsRow
+	for rows.Next() {
+		var i ListChirpDescendantsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.EditedAt,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.Kind,
+			&i.OriginalID,
+			&i.RechirpCount,
+			&i.QuoteCount,
+			&i.ReactionCounts,
+			&i.Depth,
+			pq.Array(&i.Path),
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpIDsByUser = `-- name: ListChirpIDsByUser :many
+SELECT id FROM chirps
 WHERE user_id = $1
+AND deleted_at IS NULL
+ORDER BY created_at ASC
 `
 
-func (q *Queries) GetAllChirpsByUser(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirpsByUser, userID)
+func (q *Queries) ListChirpIDsByUser(ctx context.Context, userID uuid.NullUUID) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpIDsByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpsAsc = `-- name: ListChirpsAsc :many
+SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.edited_at, c.in_reply_to, c.reply_count, c.deleted_at, c.kind, c.original_id, c.rechirp_count, c.quote_count, c.reaction_counts FROM chirps c
+JOIN users u ON u.id = c.user_id
+WHERE u.deleted_at IS NULL
+AND c.deleted_at IS NULL
+AND ($1::uuid IS NULL OR c.user_id = $1)
+AND ($2::timestamp IS NULL OR c.created_at >= $2)
+AND ($3::timestamp IS NULL OR c.created_at < $3)
+AND ($4::timestamp IS NULL OR (c.created_at, c.id) > ($4, $5::uuid))
+ORDER BY c.created_at ASC, c.id ASC
+LIMIT $6
+`
+
+type ListChirpsAscParams struct {
+	AuthorID        uuid.NullUUID
+	Since           sql.NullTime
+	Until           sql.NullTime
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	MaxRows         sql.NullInt32
+}
+
+func (q *Queries) ListChirpsAsc(ctx context.Context, arg ListChirpsAscParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsAsc,
+		arg.AuthorID,
+		arg.Since,
+		arg.Until,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.MaxRows,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -105,6 +587,15 @@ func (q *Queries) GetAllChirpsByUser(ctx context.Context, userID uuid.UUID) ([]C
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.EditedAt,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.Kind,
+			&i.OriginalID,
+			&i.RechirpCount,
+			&i.QuoteCount,
+			&i.ReactionCounts,
 		); err != nil {
 			return nil, err
 		}
@@ -119,13 +610,188 @@ func (q *Queries) GetAllChirpsByUser(ctx context.Context, userID uuid.UUID) ([]C
 	return items, nil
 }
 
-const getChirpByID = `-- name: GetChirpByID :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+const listChirpsDesc = `-- name: ListChirpsDesc :many
+SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.edited_at, c.in_reply_to, c.reply_count, c.deleted_at, c.kind, c.original_id, c.rechirp_count, c.quote_count, c.reaction_counts FROM chirps c
+JOIN users u ON u.id = c.user_id
+WHERE u.deleted_at IS NULL
+AND c.deleted_at IS NULL
+AND ($1::uuid IS NULL OR c.user_id = $1)
+AND ($2::timestamp IS NULL OR c.created_at >= $2)
+AND ($3::timestamp IS NULL OR c.created_at < $3)
+AND ($4::timestamp IS NULL OR (c.created_at, c.id) < ($4, $5::uuid))
+ORDER BY c.created_at DESC, c.id DESC
+LIMIT $6
+`
+
+type ListChirpsDescParams struct {
+	AuthorID        uuid.NullUUID
+	Since           sql.NullTime
+	Until           sql.NullTime
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	MaxRows         sql.NullInt32
+}
+
+func (q *Queries) ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsDesc,
+		arg.AuthorID,
+		arg.Since,
+		arg.Until,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.EditedAt,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.Kind,
+			&i.OriginalID,
+			&i.RechirpCount,
+			&i.QuoteCount,
+			&i.ReactionCounts,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRechirps = `-- name: ListRechirps :many
+SELECT c.id, c.user_id, c.created_at FROM chirps c
+JOIN users u ON u.id = c.user_id
+WHERE c.original_id = $1
+AND c.kind = 'rechirp'
+AND u.deleted_at IS NULL
+AND ($2::timestamp IS NULL OR (c.created_at, c.id) < ($2, $3::uuid))
+ORDER BY c.created_at DESC, c.id DESC
+LIMIT $4
+`
+
+type ListRechirpsParams struct {
+	OriginalID      uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	MaxRows         int32
+}
+
+type ListRechirpsRow struct {
+	ID        uuid.UUID
+	UserID    uuid.NullUUID
+	CreatedAt time.Time
+}
+
+func (q *Queries) ListRechirps(ctx context.Context, arg ListRechirpsParams) ([]ListRechirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listRechirps,
+		arg.OriginalID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRechirpsRow
+	for rows.Next() {
+		var i ListRechirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const resetChirps = `-- name: ResetChirps :exec
+DELETE FROM chirps
+`
+
+func (q *Queries) ResetChirps(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, resetChirps)
+	return err
+}
+
+const tombstoneChirp = `-- name: TombstoneChirp :one
+UPDATE chirps SET body = '',
+rechirp_count = 0,
+reaction_counts = '{}',
+deleted_at = NOW(),
+updated_at = NOW()
 WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id, edited_at, in_reply_to, reply_count, deleted_at, kind, original_id, rechirp_count, quote_count, reaction_counts
 `
 
-func (q *Queries) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, getChirpByID, id)
+func (q *Queries) TombstoneChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, tombstoneChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.EditedAt,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.Kind,
+		&i.OriginalID,
+		&i.RechirpCount,
+		&i.QuoteCount,
+		&i.ReactionCounts,
+	)
+	return i, err
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps SET body = $1,
+edited_at = NOW(),
+updated_at = NOW()
+WHERE id = $2
+AND deleted_at IS NULL
+AND created_at > NOW() - make_interval(secs => $3::float8)
+RETURNING id, created_at, updated_at, body, user_id, edited_at, in_reply_to, reply_count, deleted_at, kind, original_id, rechirp_count, quote_count, reaction_counts
+`
+
+type UpdateChirpBodyParams struct {
+	Body              string
+	ID                uuid.UUID
+	EditWindowSeconds float64
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.Body, arg.ID, arg.EditWindowSeconds)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -133,6 +799,15 @@ func (q *Queries) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.EditedAt,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.Kind,
+		&i.OriginalID,
+		&i.RechirpCount,
+		&i.QuoteCount,
+		&i.ReactionCounts,
 	)
 	return i, err
 }
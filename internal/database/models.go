@@ -6,26 +6,160 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type AuditEvent struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.NullUUID
+	ActorID   uuid.NullUUID
+	Actor     string
+	EventType string
+	Detail    string
+	IpAddress string
+	UserAgent string
+}
+
 type Chirp struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Body           string
+	UserID         uuid.NullUUID
+	EditedAt       sql.NullTime
+	InReplyTo      uuid.NullUUID
+	ReplyCount     int32
+	DeletedAt      sql.NullTime
+	Kind           string
+	OriginalID     uuid.NullUUID
+	RechirpCount   int32
+	QuoteCount     int32
+	ReactionCounts json.RawMessage
+}
+
+type ChirpReaction struct {
+	ChirpID   uuid.UUID
+	UserID    uuid.UUID
+	Emoji     string
+	CreatedAt time.Time
+}
+
+type ChirpRevision struct {
+	ID         uuid.UUID
+	ChirpID    uuid.UUID
+	Body       string
+	ReplacedAt time.Time
+}
+
+type Invite struct {
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	CreatedBy        uuid.NullUUID
+	CodeHash         string
+	MaxUses          int32
+	Uses             int32
+	ExpiresAt        sql.NullTime
+	RevokedAt        sql.NullTime
+	CreatedByDeleted bool
+}
+
+type InviteRedemption struct {
+	UserID     uuid.UUID
+	InviteID   uuid.UUID
+	RedeemedAt time.Time
+}
+
+type MagicLink struct {
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type MfaChallenge struct {
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	Attempts  int32
+	UsedAt    sql.NullTime
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      string
+	CreatedAt     time.Time
+	ClientID      uuid.UUID
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scopes        []string
+	CodeChallenge string
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type OauthClient struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	OwnerID      uuid.UUID
+	Name         string
+	SecretHash   sql.NullString
+	RedirectUris []string
+	Scopes       []string
+}
+
+type PasswordResetToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UserID     uuid.UUID
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+type RecoveryCode struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
-	Body      string
 	UserID    uuid.UUID
+	CodeHash  string
+	UsedAt    sql.NullTime
 }
 
 type RefreshToken struct {
-	Token     string
+	Token      string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	ExpiresAt  time.Time
+	RevokedAt  sql.NullTime
+	FamilyID   uuid.UUID
+	ReplacedBy sql.NullString
+	UserAgent  string
+	IpAddress  string
+	ClientID   uuid.NullUUID
+	Scopes     []string
+}
+
+type RevokedAccessToken struct {
+	Jti       string
 	CreatedAt time.Time
-	UpdatedAt time.Time
 	UserID    uuid.UUID
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
 }
 
 type User struct {
@@ -35,4 +169,11 @@ type User struct {
 	Email          string
 	HashedPassword string
 	IsChirpyRed    bool
+	TotpSecret     sql.NullString
+	TotpEnabled    bool
+	TotpLastStep   int64
+	EmailVerified  bool
+	Role           string
+	PendingEmail   sql.NullString
+	DeletedAt      sql.NullTime
 }
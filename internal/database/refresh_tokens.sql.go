@@ -7,13 +7,67 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const getLiveRefreshToken = `-- name: GetLiveRefreshToken :one
+SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by, user_agent, ip_address, client_id, scopes FROM refresh_tokens
+WHERE token = $1
+AND revoked_at IS NULL
+AND expires_at > NOW()
+`
+
+func (q *Queries) GetLiveRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getLiveRefreshToken, token)
+	var i RefreshToken
+	err := row.Scan(
+		&i.Token,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ReplacedBy,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.ClientID,
+		pq.Array(&i.Scopes),
+	)
+	return i, err
+}
+
+const getRefreshToken = `-- name: GetRefreshToken :one
+SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by, user_agent, ip_address, client_id, scopes FROM refresh_tokens
+WHERE token = $1
+`
+
+func (q *Queries) GetRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshToken, token)
+	var i RefreshToken
+	err := row.Scan(
+		&i.Token,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ReplacedBy,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.ClientID,
+		pq.Array(&i.Scopes),
+	)
+	return i, err
+}
+
 const getUserByRefreshToken = `-- name: GetUserByRefreshToken :one
-SELECT u.id, u.created_at, u.updated_at, u.email, u.hashed_password, u.is_chirpy_red
+SELECT u.id, u.created_at, u.updated_at, u.email, u.hashed_password, u.is_chirpy_red, u.totp_secret, u.totp_enabled, u.totp_last_step, u.email_verified, u.role, u.pending_email, u.deleted_at
 FROM users u
 JOIN refresh_tokens rt ON u.id = rt.user_id
 WHERE rt.token = $1
@@ -31,15 +85,105 @@ func (q *Queries) GetUserByRefreshToken(ctx context.Context, token string) (User
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerified,
+		&i.Role,
+		&i.PendingEmail,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
+const listActiveSessions = `-- name: ListActiveSessions :many
+SELECT rt.family_id,
+    (SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = rt.family_id)::TIMESTAMP AS created_at,
+    rt.created_at AS last_used_at,
+    rt.expires_at,
+    rt.user_agent,
+    rt.ip_address
+FROM refresh_tokens rt
+WHERE rt.user_id = $1
+AND rt.revoked_at IS NULL
+AND rt.expires_at > NOW()
+ORDER BY rt.created_at DESC
+`
+
+type ListActiveSessionsRow struct {
+	FamilyID   uuid.UUID
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	UserAgent  string
+	IpAddress  string
+}
+
+func (q *Queries) ListActiveSessions(ctx context.Context, userID uuid.UUID) ([]ListActiveSessionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listActiveSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListActiveSessionsRow
+	for rows.Next() {
+		var i ListActiveSessionsRow
+		if err := rows.Scan(
+			&i.FamilyID,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+			&i.ExpiresAt,
+			&i.UserAgent,
+			&i.IpAddress,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeAllRefreshTokensForUser = `-- name: RevokeAllRefreshTokensForUser :exec
+UPDATE refresh_tokens SET revoked_at = NOW(),
+updated_at = NOW()
+WHERE user_id = $1
+AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAllRefreshTokensForUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeAllRefreshTokensForUser, userID)
+	return err
+}
+
+const revokeOtherSessions = `-- name: RevokeOtherSessions :exec
+UPDATE refresh_tokens SET revoked_at = NOW(),
+updated_at = NOW()
+WHERE user_id = $1
+AND family_id <> $2
+AND revoked_at IS NULL
+`
+
+type RevokeOtherSessionsParams struct {
+	UserID   uuid.UUID
+	FamilyID uuid.UUID
+}
+
+func (q *Queries) RevokeOtherSessions(ctx context.Context, arg RevokeOtherSessionsParams) error {
+	_, err := q.db.ExecContext(ctx, revokeOtherSessions, arg.UserID, arg.FamilyID)
+	return err
+}
+
 const revokeRefreshToken = `-- name: RevokeRefreshToken :one
 UPDATE refresh_tokens SET revoked_at = NOW(),
 updated_at = NOW()
 WHERE token = $1
-RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by, user_agent, ip_address, client_id, scopes
 `
 
 func (q *Queries) RevokeRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
@@ -52,30 +196,123 @@ func (q *Queries) RevokeRefreshToken(ctx context.Context, token string) (Refresh
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ReplacedBy,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.ClientID,
+		pq.Array(&i.Scopes),
+	)
+	return i, err
+}
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens SET revoked_at = NOW(),
+updated_at = NOW()
+WHERE family_id = $1
+AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, familyID)
+	return err
+}
+
+const revokeSessionForUser = `-- name: RevokeSessionForUser :execrows
+UPDATE refresh_tokens SET revoked_at = NOW(),
+updated_at = NOW()
+WHERE family_id = $1
+AND user_id = $2
+AND revoked_at IS NULL
+`
+
+type RevokeSessionForUserParams struct {
+	FamilyID uuid.UUID
+	UserID   uuid.UUID
+}
+
+func (q *Queries) RevokeSessionForUser(ctx context.Context, arg RevokeSessionForUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeSessionForUser, arg.FamilyID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rotateRefreshToken = `-- name: RotateRefreshToken :one
+UPDATE refresh_tokens SET revoked_at = NOW(),
+updated_at = NOW(),
+replaced_by = $2
+WHERE token = $1
+AND revoked_at IS NULL
+AND expires_at > NOW()
+RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by, user_agent, ip_address, client_id, scopes
+`
+
+type RotateRefreshTokenParams struct {
+	Token      string
+	ReplacedBy sql.NullString
+}
+
+func (q *Queries) RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, rotateRefreshToken, arg.Token, arg.ReplacedBy)
+	var i RefreshToken
+	err := row.Scan(
+		&i.Token,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ReplacedBy,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.ClientID,
+		pq.Array(&i.Scopes),
 	)
 	return i, err
 }
 
 const storeRefreshToken = `-- name: StoreRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at)
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, family_id, user_agent, ip_address, client_id, scopes)
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
-    $3
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8
 )
-RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by, user_agent, ip_address, client_id, scopes
 `
 
 type StoreRefreshTokenParams struct {
 	Token     string
 	UserID    uuid.UUID
 	ExpiresAt time.Time
+	FamilyID  uuid.UUID
+	UserAgent string
+	IpAddress string
+	ClientID  uuid.NullUUID
+	Scopes    []string
 }
 
 func (q *Queries) StoreRefreshToken(ctx context.Context, arg StoreRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, storeRefreshToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, storeRefreshToken,
+		arg.Token,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.FamilyID,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.ClientID,
+		pq.Array(arg.Scopes),
+	)
 	var i RefreshToken
 	err := row.Scan(
 		&i.Token,
@@ -84,6 +321,12 @@ func (q *Queries) StoreRefreshToken(ctx context.Context, arg StoreRefreshTokenPa
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ReplacedBy,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.ClientID,
+		pq.Array(&i.Scopes),
 	)
 	return i, err
 }
@@ -7,10 +7,47 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
 )
 
+const confirmPendingEmail = `-- name: ConfirmPendingEmail :one
+UPDATE users SET email = pending_email,
+pending_email = NULL,
+email_verified = TRUE,
+updated_at = NOW()
+WHERE id = $1
+AND pending_email = $2
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled, totp_last_step, email_verified, role, pending_email, deleted_at
+`
+
+type ConfirmPendingEmailParams struct {
+	ID           uuid.UUID
+	PendingEmail sql.NullString
+}
+
+func (q *Queries) ConfirmPendingEmail(ctx context.Context, arg ConfirmPendingEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, confirmPendingEmail, arg.ID, arg.PendingEmail)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerified,
+		&i.Role,
+		&i.PendingEmail,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (id, created_at, updated_at, email, hashed_password)
 VALUES (
@@ -20,7 +57,7 @@ VALUES (
     $1,
     $2
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled, totp_last_step, email_verified, role, pending_email, deleted_at
 `
 
 type CreateUserParams struct {
@@ -38,12 +75,76 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerified,
+		&i.Role,
+		&i.PendingEmail,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const disableTOTP = `-- name: DisableTOTP :exec
+UPDATE users SET totp_secret = NULL,
+totp_enabled = FALSE,
+totp_last_step = 0,
+updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) DisableTOTP(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, disableTOTP, id)
+	return err
+}
+
+const enableTOTP = `-- name: EnableTOTP :exec
+UPDATE users SET totp_enabled = TRUE,
+totp_last_step = $2,
+updated_at = NOW()
+WHERE id = $1
+`
+
+type EnableTOTPParams struct {
+	ID           uuid.UUID
+	TotpLastStep int64
+}
+
+func (q *Queries) EnableTOTP(ctx context.Context, arg EnableTOTPParams) error {
+	_, err := q.db.ExecContext(ctx, enableTOTP, arg.ID, arg.TotpLastStep)
+	return err
+}
+
+const getDeletedUserByID = `-- name: GetDeletedUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled, totp_last_step, email_verified, role, pending_email, deleted_at FROM users
+WHERE id = $1
+AND deleted_at IS NOT NULL
+`
+
+func (q *Queries) GetDeletedUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getDeletedUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerified,
+		&i.Role,
+		&i.PendingEmail,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE email = $1
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled, totp_last_step, email_verified, role, pending_email, deleted_at FROM users WHERE lower(email) = lower($1) AND deleted_at IS NULL
 `
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
@@ -56,10 +157,129 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerified,
+		&i.Role,
+		&i.PendingEmail,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled, totp_last_step, email_verified, role, pending_email, deleted_at FROM users WHERE id = $1 AND deleted_at IS NULL
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerified,
+		&i.Role,
+		&i.PendingEmail,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const listUsersToPurge = `-- name: ListUsersToPurge :many
+SELECT id FROM users
+WHERE deleted_at < $1
+ORDER BY deleted_at ASC
+`
+
+func (q *Queries) ListUsersToPurge(ctx context.Context, deletedAt sql.NullTime) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersToPurge, deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markEmailVerified = `-- name: MarkEmailVerified :one
+UPDATE users SET email_verified = TRUE,
+updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled, totp_last_step, email_verified, role, pending_email, deleted_at
+`
+
+func (q *Queries) MarkEmailVerified(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, markEmailVerified, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerified,
+		&i.Role,
+		&i.PendingEmail,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const purgeUser = `-- name: PurgeUser :execrows
+DELETE FROM users
+WHERE id = $1
+AND deleted_at IS NOT NULL
+`
+
+func (q *Queries) PurgeUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rehashUserPassword = `-- name: RehashUserPassword :exec
+UPDATE users SET hashed_password = $1
+WHERE id = $2 AND hashed_password = $3
+`
+
+type RehashUserPasswordParams struct {
+	NewHash string
+	ID      uuid.UUID
+	OldHash string
+}
+
+func (q *Queries) RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, rehashUserPassword, arg.NewHash, arg.ID, arg.OldHash)
+	return err
+}
+
 const resetUsers = `-- name: ResetUsers :exec
 DELETE FROM users
 `
@@ -69,22 +289,21 @@ func (q *Queries) ResetUsers(ctx context.Context) error {
 	return err
 }
 
-const updateUserCredentials = `-- name: UpdateUserCredentials :one
-UPDATE users SET email = $1,
-hashed_password = $2,
+const restoreUser = `-- name: RestoreUser :one
+UPDATE users SET deleted_at = NULL,
 updated_at = NOW()
-WHERE id = $3
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+WHERE id = $1
+AND deleted_at = $2
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled, totp_last_step, email_verified, role, pending_email, deleted_at
 `
 
-type UpdateUserCredentialsParams struct {
-	Email          string
-	HashedPassword string
-	ID             uuid.UUID
+type RestoreUserParams struct {
+	ID        uuid.UUID
+	DeletedAt sql.NullTime
 }
 
-func (q *Queries) UpdateUserCredentials(ctx context.Context, arg UpdateUserCredentialsParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, updateUserCredentials, arg.Email, arg.HashedPassword, arg.ID)
+func (q *Queries) RestoreUser(ctx context.Context, arg RestoreUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, restoreUser, arg.ID, arg.DeletedAt)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -93,15 +312,150 @@ func (q *Queries) UpdateUserCredentials(ctx context.Context, arg UpdateUserCrede
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerified,
+		&i.Role,
+		&i.PendingEmail,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
+const setPendingEmail = `-- name: SetPendingEmail :one
+UPDATE users SET pending_email = $2,
+updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled, totp_last_step, email_verified, role, pending_email, deleted_at
+`
+
+type SetPendingEmailParams struct {
+	ID           uuid.UUID
+	PendingEmail sql.NullString
+}
+
+func (q *Queries) SetPendingEmail(ctx context.Context, arg SetPendingEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setPendingEmail, arg.ID, arg.PendingEmail)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerified,
+		&i.Role,
+		&i.PendingEmail,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const setTOTPSecret = `-- name: SetTOTPSecret :exec
+UPDATE users SET totp_secret = $2,
+totp_enabled = FALSE,
+updated_at = NOW()
+WHERE id = $1
+`
+
+type SetTOTPSecretParams struct {
+	ID         uuid.UUID
+	TotpSecret sql.NullString
+}
+
+func (q *Queries) SetTOTPSecret(ctx context.Context, arg SetTOTPSecretParams) error {
+	_, err := q.db.ExecContext(ctx, setTOTPSecret, arg.ID, arg.TotpSecret)
+	return err
+}
+
+const setUserRole = `-- name: SetUserRole :one
+UPDATE users SET role = $2,
+updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled, totp_last_step, email_verified, role, pending_email, deleted_at
+`
+
+type SetUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserRole, arg.ID, arg.Role)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerified,
+		&i.Role,
+		&i.PendingEmail,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const softDeleteUser = `-- name: SoftDeleteUser :one
+UPDATE users SET deleted_at = NOW(),
+updated_at = NOW()
+WHERE id = $1
+AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled, totp_last_step, email_verified, role, pending_email, deleted_at
+`
+
+func (q *Queries) SoftDeleteUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, softDeleteUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerified,
+		&i.Role,
+		&i.PendingEmail,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users SET hashed_password = $2,
+updated_at = NOW()
+WHERE id = $1
+`
+
+type UpdateUserPasswordParams struct {
+	ID             uuid.UUID
+	HashedPassword string
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.ID, arg.HashedPassword)
+	return err
+}
+
 const upgradeUserToChirpyRed = `-- name: UpgradeUserToChirpyRed :one
 UPDATE users SET is_chirpy_red = TRUE,
 updated_at = NOW()
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled, totp_last_step, email_verified, role, pending_email, deleted_at
 `
 
 func (q *Queries) UpgradeUserToChirpyRed(ctx context.Context, id uuid.UUID) (User, error) {
@@ -114,6 +468,32 @@ func (q *Queries) UpgradeUserToChirpyRed(ctx context.Context, id uuid.UUID) (Use
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerified,
+		&i.Role,
+		&i.PendingEmail,
+		&i.DeletedAt,
 	)
 	return i, err
 }
+
+const useTOTPStep = `-- name: UseTOTPStep :execrows
+UPDATE users SET totp_last_step = $2
+WHERE id = $1
+AND totp_last_step < $2
+`
+
+type UseTOTPStepParams struct {
+	ID           uuid.UUID
+	TotpLastStep int64
+}
+
+func (q *Queries) UseTOTPStep(ctx context.Context, arg UseTOTPStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useTOTPStep, arg.ID, arg.TotpLastStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
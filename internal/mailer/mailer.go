@@ -0,0 +1,167 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers plain text emails
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// format renders the message as an RFC 5322 email
+func (msg Message) format(from string) ([]byte, error) {
+	// a newline in a header would let the caller inject extra headers
+	for _, header := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, fmt.Errorf("invalid header value %q", header)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
+
+// sends that don't finish in time are given up on, so a hung relay can't
+// hold up the request that sends the mail
+const smtpTimeout = 30 * time.Second
+
+// SMTPMailer sends mail through an SMTP relay
+type SMTPMailer struct {
+	host string
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{
+		host: host,
+		addr: host + ":" + port,
+		from: from,
+	}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	data, err := msg.format(m.from)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	// the deadline covers the whole conversation, and cancelling ctx cuts it short
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// the same steps as smtp.SendMail, on a connection we control
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: m.host})
+		if err != nil {
+			return err
+		}
+	}
+	if m.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("smtp server %s doesn't support AUTH", m.host)
+		}
+		err = c.Auth(m.auth)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.Mail(m.from)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(msg.To)
+	if err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// OutboxMailer writes every message to a file instead of sending it, for local
+// development and tests
+type OutboxMailer struct {
+	dir  string
+	from string
+	mu   sync.Mutex
+	seq  int
+}
+
+func NewOutboxMailer(dir, from string) (*OutboxMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &OutboxMailer{dir: dir, from: from}, nil
+}
+
+func (m *OutboxMailer) Send(ctx context.Context, msg Message) error {
+	data, err := msg.format(m.from)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.seq++
+	name := fmt.Sprintf("%d-%04d.eml", time.Now().UnixNano(), m.seq)
+	m.mu.Unlock()
+
+	return os.WriteFile(filepath.Join(m.dir, name), data, 0o600)
+}
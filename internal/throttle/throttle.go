@@ -0,0 +1,154 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// FreeAttempts is how many failures are allowed before any delay kicks in
+	FreeAttempts int
+	// BaseDelay is the wait after the first failure past FreeAttempts, it doubles with every further failure
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutThreshold failures lock the key out for LockoutDuration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// Window is how long a failure is remembered once no new ones arrive
+	Window time.Duration
+}
+
+// Limiter counts failures per key (an account, an IP address) and tells
+// callers how long a key has to wait before its next attempt
+type Limiter struct {
+	cfg     Config
+	mu      sync.Mutex
+	entries map[string]*entry
+	calls   int
+}
+
+type entry struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// entries are swept every sweepInterval counted failures
+const sweepInterval = 1000
+
+func New(cfg Config) *Limiter {
+	return &Limiter{
+		cfg:     cfg,
+		entries: map[string]*entry{},
+	}
+}
+
+// Check returns how long the key still has to wait, zero means it may try now
+func (l *Limiter) Check(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return 0
+	}
+	wait := time.Until(e.blockedUntil)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Fail records a failed attempt for the key
+func (l *Limiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fail(key, time.Now())
+}
+
+// Reserve checks the key and counts the attempt as a failure in one step, so
+// a burst of concurrent attempts can't all get past the check before any of
+// them is counted. It returns how long the key still has to wait, and only
+// counts the attempt when that is zero. Attempts that turn out not to be
+// failures are given back with Refund.
+func (l *Limiter) Reserve(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if e, ok := l.entries[key]; ok {
+		if wait := e.blockedUntil.Sub(now); wait > 0 {
+			return wait
+		}
+	}
+	l.fail(key, now)
+	return 0
+}
+
+// Refund takes back one attempt counted by Reserve
+func (l *Limiter) Refund(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return
+	}
+	e.failures--
+	if e.failures <= 0 {
+		delete(l.entries, key)
+		return
+	}
+	e.blockedUntil = time.Time{}
+	l.block(e, e.lastFailure)
+}
+
+// fail counts a failure for the key, l.mu must be held
+func (l *Limiter) fail(key string, now time.Time) {
+	l.calls++
+	if l.calls%sweepInterval == 0 {
+		l.sweep(now)
+	}
+
+	e, ok := l.entries[key]
+	if !ok || now.Sub(e.lastFailure) > l.cfg.Window {
+		e = &entry{}
+		l.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+	l.block(e, now)
+}
+
+// block sets how long the entry has to wait after its latest failure, l.mu must be held
+func (l *Limiter) block(e *entry, now time.Time) {
+	switch {
+	case l.cfg.LockoutThreshold > 0 && e.failures >= l.cfg.LockoutThreshold:
+		e.blockedUntil = now.Add(l.cfg.LockoutDuration)
+	case e.failures > l.cfg.FreeAttempts:
+		delay := l.cfg.BaseDelay
+		for i := l.cfg.FreeAttempts + 1; i < e.failures && delay < l.cfg.MaxDelay; i++ {
+			delay *= 2
+		}
+		if delay > l.cfg.MaxDelay {
+			delay = l.cfg.MaxDelay
+		}
+		e.blockedUntil = now.Add(delay)
+	}
+}
+
+// Reset forgets every failure recorded for the key
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// sweep drops entries that are neither blocked nor inside the window, l.mu must be held
+func (l *Limiter) sweep(now time.Time) {
+	for key, e := range l.entries {
+		if now.After(e.blockedUntil) && now.Sub(e.lastFailure) > l.cfg.Window {
+			delete(l.entries, key)
+		}
+	}
+}
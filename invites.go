@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cryptidcodes/chirpy/internal/auth"
+	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// registration modes, picked with REGISTRATION_MODE
+const (
+	registrationOpen   = "open"
+	registrationInvite = "invite"
+	registrationClosed = "closed"
+)
+
+// users who aren't admins can only hand out a few invites that expire
+const (
+	userInviteMaxUses       = 5
+	userInviteMaxExpiryDays = 30
+	defaultInviteExpiryDays = 7
+)
+
+// registrationModeFromEnv reads REGISTRATION_MODE, which defaults to open
+func registrationModeFromEnv() (string, error) {
+	mode := os.Getenv("REGISTRATION_MODE")
+	switch mode {
+	case "":
+		return registrationOpen, nil
+	case registrationOpen, registrationInvite, registrationClosed:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown registration mode %q, use open, invite or closed", mode)
+	}
+}
+
+// DO NOT DELETE: USED IN RESPONSE STRUCTURES
+// database.Invite DOES NOT HAVE JSON TAGS
+type Invite struct {
+	ID               uuid.UUID  `json:"id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	CreatedBy        *uuid.UUID `json:"created_by"`
+	CreatedByDeleted bool       `json:"created_by_deleted"`
+	MaxUses          int32      `json:"max_uses"`
+	Uses             int32      `json:"uses"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	RevokedAt        *time.Time `json:"revoked_at"`
+}
+
+func inviteFromDB(invite database.Invite) Invite {
+	resp := Invite{
+		ID:               invite.ID,
+		CreatedAt:        invite.CreatedAt,
+		CreatedByDeleted: invite.CreatedByDeleted,
+		MaxUses:          invite.MaxUses,
+		Uses:             invite.Uses,
+	}
+	if invite.CreatedBy.Valid {
+		resp.CreatedBy = &invite.CreatedBy.UUID
+	}
+	if invite.ExpiresAt.Valid {
+		resp.ExpiresAt = &invite.ExpiresAt.Time
+	}
+	if invite.RevokedAt.Valid {
+		resp.RevokedAt = &invite.RevokedAt.Time
+	}
+	return resp
+}
+
+func (cfg *apiConfig) handlerCreateInvite(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MaxUses       *int32 `json:"max_uses"`
+		ExpiresInDays *int   `json:"expires_in_days"`
+	}
+	type response struct {
+		Invite
+		Code string `json:"code"`
+	}
+
+	caller := principalFromContext(r.Context())
+	isAdmin := caller.AdminKey || caller.hasRole(roleAdmin)
+
+	// invites vouch for the people who use them, so unverified users can't make any
+	if !caller.AdminKey && !caller.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "Email address must be verified before creating invites", nil)
+		return
+	}
+
+	// decode JSON request body
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	maxUses := int32(1)
+	if params.MaxUses != nil {
+		maxUses = *params.MaxUses
+	}
+	if maxUses < 1 {
+		respondWithError(w, http.StatusBadRequest, "max_uses must be at least 1", nil)
+		return
+	}
+	if !isAdmin && maxUses > userInviteMaxUses {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("max_uses can't be more than %d", userInviteMaxUses), nil)
+		return
+	}
+
+	// admins can make invites that never expire by asking for 0 days
+	expiresInDays := defaultInviteExpiryDays
+	if params.ExpiresInDays != nil {
+		expiresInDays = *params.ExpiresInDays
+	}
+	if expiresInDays < 0 || (!isAdmin && (expiresInDays < 1 || expiresInDays > userInviteMaxExpiryDays)) {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("expires_in_days must be between 1 and %d", userInviteMaxExpiryDays), nil)
+		return
+	}
+	expiresAt := sql.NullTime{}
+	if expiresInDays > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().Add(time.Duration(expiresInDays) * 24 * time.Hour), Valid: true}
+	}
+
+	createdBy := uuid.NullUUID{}
+	if !caller.AdminKey {
+		createdBy = uuid.NullUUID{UUID: caller.UserID, Valid: true}
+	}
+
+	// only the hash is stored, the code itself is only shown in this response
+	code := auth.MakeInviteCode()
+	invite, err := cfg.dbQueries.CreateInvite(r.Context(), database.CreateInviteParams{
+		CreatedBy: createdBy,
+		CodeHash:  auth.HashToken(code),
+		MaxUses:   maxUses,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create invite", err)
+		return
+	}
+	// admins get past the limits, so their invites are audited
+	if isAdmin {
+		cfg.recordSecurityEvent(r, uuid.Nil, eventAdminInviteCreated, fmt.Sprintf("invite %s, max_uses %d", invite.ID, maxUses))
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Invite: inviteFromDB(invite),
+		Code:   code,
+	})
+}
+
+func (cfg *apiConfig) handlerListInvites(w http.ResponseWriter, r *http.Request) {
+	userID := principalFromContext(r.Context()).UserID
+
+	invites, err := cfg.dbQueries.ListInvitesByCreator(r.Context(), uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve invites", err)
+		return
+	}
+	respondWithInvites(w, invites)
+}
+
+func (cfg *apiConfig) handlerAdminListInvites(w http.ResponseWriter, r *http.Request) {
+	invites, err := cfg.dbQueries.ListInvites(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve invites", err)
+		return
+	}
+	respondWithInvites(w, invites)
+}
+
+func respondWithInvites(w http.ResponseWriter, invites []database.Invite) {
+	resp := make([]Invite, len(invites))
+	for i := range invites {
+		resp[i] = inviteFromDB(invites[i])
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerRevokeInvite stops an invite from being used again. Users can revoke
+// their own invites, admins can revoke anyone's.
+func (cfg *apiConfig) handlerRevokeInvite(w http.ResponseWriter, r *http.Request) {
+	caller := principalFromContext(r.Context())
+
+	// extract inviteID from URL
+	inviteID, err := uuid.Parse(r.PathValue("inviteID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid invite ID", err)
+		return
+	}
+
+	createdBy := uuid.NullUUID{}
+	if !caller.AdminKey && !caller.hasRole(roleAdmin) {
+		createdBy = uuid.NullUUID{UUID: caller.UserID, Valid: true}
+	}
+	revoked, err := cfg.dbQueries.RevokeInvite(r.Context(), database.RevokeInviteParams{
+		ID:        inviteID,
+		CreatedBy: createdBy,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke invite", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Invite not found", nil)
+		return
+	}
+	if !createdBy.Valid {
+		cfg.recordSecurityEvent(r, uuid.Nil, eventAdminInviteRevoked, "invite "+inviteID.String())
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerListInviteRedemptions shows who signed up with an invite
+func (cfg *apiConfig) handlerListInviteRedemptions(w http.ResponseWriter, r *http.Request) {
+	type redemption struct {
+		UserID     uuid.UUID `json:"user_id"`
+		Email      string    `json:"email"`
+		RedeemedAt time.Time `json:"redeemed_at"`
+	}
+
+	// extract inviteID from URL
+	inviteID, err := uuid.Parse(r.PathValue("inviteID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid invite ID", err)
+		return
+	}
+
+	redemptions, err := cfg.dbQueries.ListInviteRedemptions(r.Context(), inviteID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve redemptions", err)
+		return
+	}
+
+	resp := make([]redemption, len(redemptions))
+	for i := range redemptions {
+		resp[i] = redemption{
+			UserID:     redemptions[i].UserID,
+			Email:      redemptions[i].Email,
+			RedeemedAt: redemptions[i].RedeemedAt,
+		}
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// createUserWithInvite uses up one use of the invite and creates the user in
+// a single transaction, so a failed signup doesn't burn a use and two signups
+// can't both take the last one. sql.ErrNoRows means the code can't be used.
+func (cfg *apiConfig) createUserWithInvite(ctx context.Context, code string, params database.CreateUserParams) (database.User, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	invite, err := qtx.RedeemInvite(ctx, auth.HashToken(code))
+	if err != nil {
+		return database.User{}, err
+	}
+
+	user, err := qtx.CreateUser(ctx, params)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	err = qtx.CreateInviteRedemption(ctx, database.CreateInviteRedemptionParams{
+		UserID:   user.ID,
+		InviteID: invite.ID,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+
+	return user, tx.Commit()
+}
@@ -0,0 +1,9 @@
+package main
+
+import "net/http"
+
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	// public keys only change on rotation, so let verifiers cache them for a while
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, cfg.jwtKeys.JWKS())
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerUnlockAccount(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+		IP    string `json:"ip"`
+	}
+
+	// decode JSON request body
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Email == "" && params.IP == "" {
+		respondWithError(w, http.StatusBadRequest, "An email or an IP address is required", nil)
+		return
+	}
+
+	if params.Email != "" {
+		cfg.accountThrottle.Reset(loginAccountKey(params.Email))
+		// attach the event to the account when there is one
+		userID := uuid.Nil
+		if user, err := cfg.dbQueries.GetUserByEmail(r.Context(), params.Email); err == nil {
+			userID = user.ID
+		}
+		cfg.recordSecurityEvent(r, userID, eventAdminAccountUnlocked, params.Email)
+	}
+	if params.IP != "" {
+		cfg.ipThrottle.Reset("ip:" + params.IP)
+		cfg.recordSecurityEvent(r, uuid.Nil, eventAdminIPUnlocked, params.IP)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
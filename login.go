@@ -1,12 +1,29 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cryptidcodes/chirpy/internal/auth"
 	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/cryptidcodes/chirpy/internal/throttle"
+	"github.com/google/uuid"
+)
+
+const (
+	// access tokens are short-lived, clients use their refresh token to get new ones
+	accessTokenDuration = time.Hour
+	// how long a user has to enter their 2FA code after a correct password
+	mfaChallengeDuration = 5 * time.Minute
+	// how many codes can be tried against one challenge before a new password login is needed
+	mfaChallengeMaxAttempts = 5
 )
 
 func (cfg *apiConfig) handlerLoginUser(w http.ResponseWriter, r *http.Request) {
@@ -14,12 +31,8 @@ func (cfg *apiConfig) handlerLoginUser(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
-	}
-
-	type respUser struct {
-		User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
+		// UseCookies asks for a browser session, see respondWithNewSession
+		UseCookies bool `json:"use_cookies"`
 	}
 
 	// decode JSON request body
@@ -31,21 +44,166 @@ func (cfg *apiConfig) handlerLoginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// refuse early if this account or this client has failed too often, before spending a hash on it
+	accountKey := loginAccountKey(params.Email)
+	ipKey := loginIPKey(r)
+	if !cfg.reserveLoginAttempt(w, accountKey, ipKey) {
+		return
+	}
+	failed := false
+	defer cfg.settleLoginAttempt(accountKey, ipKey, &failed)
+
 	// get user from database by email
 	user, err := cfg.dbQueries.GetUserByEmail(r.Context(), params.Email)
 	if err != nil {
+		failed = true
+		// no account to attach this to. The hash lets investigators spot email
+		// guessing without storing whatever the client sent, and the IP throttle
+		// above bounds how many of these one client can write.
+		cfg.recordSecurityEvent(r, uuid.Nil, eventLoginFailed, "unknown email sha256:"+auth.HashToken(strings.ToLower(strings.TrimSpace(params.Email))))
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
 	// check the password
-	match, err := auth.CheckPasswordHash(params.Password, user.HashedPassword)
+	match, needsRehash, err := cfg.hasher.CheckPasswordHash(r.Context(), params.Password, user.HashedPassword)
+	if errors.Is(err, auth.ErrHasherBusy) {
+		respondWithHashError(w, err)
+		return
+	}
 	if err != nil || !match {
+		failed = true
+		cfg.recordSecurityEvent(r, user.ID, eventLoginFailed, "wrong password")
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
+	if needsRehash {
+		cfg.rehashPassword(r.Context(), user, params.Password)
+	}
+
+	// users with 2FA get a challenge token to trade in at /api/login/2fa along with their code
+	if user.TotpEnabled {
+		cfg.respondWithMFAChallenge(w, r, user)
+		return
+	}
+
+	// the IP counter is left alone, otherwise an attacker could clear it with their own account.
+	// With 2FA on, the account counter is only cleared once the second factor passes too.
+	cfg.accountThrottle.Reset(accountKey)
+	cfg.recordOwnerEvent(r, user.ID, eventLogin, "password")
+	cfg.respondWithNewSession(w, r, user, params.UseCookies)
+}
+
+// respondWithMFAChallenge sends the token a user with 2FA needs to finish logging in
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, r *http.Request, user database.User) {
+	type respChallenge struct {
+		MFARequired    bool   `json:"mfa_required"`
+		ChallengeToken string `json:"challenge_token"`
+	}
+
+	challenge, err := auth.MakePurposeJWT(user.ID, auth.PurposeMFAChallenge, "", cfg.jwtKeys, mfaChallengeDuration)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create challenge token", err)
+		return
+	}
+	// the token is signed, the table makes it single-use and caps the codes tried against it
+	err = cfg.dbQueries.CreateMFAChallenge(r.Context(), database.CreateMFAChallengeParams{
+		TokenHash: auth.HashToken(challenge),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(mfaChallengeDuration),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create challenge token", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, respChallenge{
+		MFARequired:    true,
+		ChallengeToken: challenge,
+	})
+}
+
+// rehashPassword upgrades a hash made with old parameters. It is best effort,
+// the login goes ahead with the old hash if anything fails.
+func (cfg *apiConfig) rehashPassword(ctx context.Context, user database.User, password string) {
+	newHash, err := cfg.hasher.HashPassword(ctx, password)
+	if err != nil {
+		log.Printf("Error rehashing password: %s", err)
+		return
+	}
+	// only replace the hash we checked, in case the password changed in the meantime
+	err = cfg.dbQueries.RehashUserPassword(ctx, database.RehashUserPasswordParams{
+		NewHash: newHash,
+		ID:      user.ID,
+		OldHash: user.HashedPassword,
+	})
+	if err != nil {
+		log.Printf("Error storing rehashed password: %s", err)
+	}
+}
+
+func loginAccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func loginIPKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// reserveLoginAttempt counts the attempt against both keys before it is
+// made, so concurrent guesses can't slip past the limits together. It
+// responds with 429 and returns false if either key has to wait.
+func (cfg *apiConfig) reserveLoginAttempt(w http.ResponseWriter, accountKey, ipKey string) bool {
+	return reserveAttempt(w, "Too many failed login attempts, try again later",
+		cfg.accountThrottle, accountKey, cfg.ipThrottle, ipKey)
+}
+
+// reserveAttempt counts an attempt against an account key and an IP key,
+// each with its own limiter. If either has to wait nothing is counted, and
+// it responds with 429 and message and returns false.
+func reserveAttempt(w http.ResponseWriter, message string, accountThrottle *throttle.Limiter, accountKey string, ipThrottle *throttle.Limiter, ipKey string) bool {
+	wait := accountThrottle.Reserve(accountKey)
+	if wait == 0 {
+		wait = ipThrottle.Reserve(ipKey)
+		if wait > 0 {
+			accountThrottle.Refund(accountKey)
+		}
+	}
+	if wait == 0 {
+		return true
+	}
+
+	// round up so clients never retry a moment too early
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, message, nil)
+	return false
+}
+
+// settleLoginAttempt gives back an attempt reserved by reserveLoginAttempt
+// unless it turned out to be a failure, meant to be deferred
+func (cfg *apiConfig) settleLoginAttempt(accountKey, ipKey string, failed *bool) {
+	if *failed {
+		return
+	}
+	cfg.accountThrottle.Refund(accountKey)
+	cfg.ipThrottle.Refund(ipKey)
+}
+
+// respondWithNewSession issues an access token and a refresh token for a
+// fully authenticated user and sends them back with the user's details.
+// With useCookies the tokens go into HttpOnly cookies instead of the body,
+// and the body carries the CSRF token the browser has to send back.
+func (cfg *apiConfig) respondWithNewSession(w http.ResponseWriter, r *http.Request, user database.User, useCookies bool) {
+	type respUser struct {
+		User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	type respCookieUser struct {
+		User
+		CSRFToken string `json:"csrf_token"`
+	}
 
 	// generate JWT
-	JWT, err := auth.MakeJWT(user.ID, cfg.secretKey, time.Hour)
+	JWT, err := auth.MakeJWT(user.ID, cfg.jwtKeys, accessTokenDuration)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create JWT", err)
 		return
@@ -54,13 +212,14 @@ func (cfg *apiConfig) handlerLoginUser(w http.ResponseWriter, r *http.Request) {
 	// generate refresh token
 	refreshToken := auth.MakeRefreshToken()
 
-	// store refresh token in database
-	now := time.Now()
-	refreshTokenExpiry := now.Add(60 * 24 * time.Hour) // refresh token valid for 60 days
+	// store refresh token in database as the first token of a new family
 	_, err = cfg.dbQueries.StoreRefreshToken(r.Context(), database.StoreRefreshTokenParams{
 		Token:     refreshToken,
 		UserID:    user.ID,
-		ExpiresAt: refreshTokenExpiry,
+		ExpiresAt: time.Now().Add(refreshTokenDuration),
+		FamilyID:  uuid.New(),
+		UserAgent: userAgent(r),
+		IpAddress: clientIP(r),
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't store refresh token", err)
@@ -68,14 +227,25 @@ func (cfg *apiConfig) handlerLoginUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// create and send JSON response
+	respUserData := User{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		IsChirpyRed:   user.IsChirpyRed,
+		EmailVerified: user.EmailVerified,
+		Role:          user.Role,
+	}
+	if useCookies {
+		cfg.setSessionCookies(w, JWT, refreshToken)
+		respondWithJSON(w, http.StatusOK, respCookieUser{
+			User:      respUserData,
+			CSRFToken: cfg.setCSRFCookie(w),
+		})
+		return
+	}
 	respondWithJSON(w, http.StatusOK, respUser{
-		User: User{
-			ID:          user.ID,
-			CreatedAt:   user.CreatedAt,
-			UpdatedAt:   user.UpdatedAt,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
+		User:         respUserData,
 		Token:        JWT,
 		RefreshToken: refreshToken,
 	})
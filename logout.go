@@ -0,0 +1,28 @@
+package main
+
+import "net/http"
+
+func (cfg *apiConfig) handlerLogout(w http.ResponseWriter, r *http.Request) {
+	caller := principalFromContext(r.Context())
+
+	// the access token stays on the denylist until it would have expired anyway
+	token := caller.AccessToken
+	err := cfg.tokenDenylist.Revoke(r.Context(), token.ID, caller.UserID, token.ExpiresAt)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke token", err)
+		return
+	}
+
+	// a browser session ends completely, its refresh token is in a cookie the frontend can't reach
+	if c, err := r.Cookie(refreshCookie); err == nil && c.Value != "" {
+		_, err = cfg.dbQueries.RevokeRefreshToken(r.Context(), c.Value)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke refresh token", err)
+			return
+		}
+	}
+	cfg.clearSessionCookies(w)
+	cfg.recordSecurityEvent(r, caller.UserID, eventLogout, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
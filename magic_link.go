@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cryptidcodes/chirpy/internal/auth"
+	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/cryptidcodes/chirpy/internal/mailer"
+)
+
+const (
+	// magic links are only good for a few minutes
+	magicLinkDuration = 10 * time.Minute
+	// magicLinkCookie holds the request token in the browser that asked for the link
+	magicLinkCookie = "chirpy_magic"
+)
+
+func (cfg *apiConfig) handlerRequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+	type response struct {
+		RequestToken string `json:"request_token"`
+	}
+
+	// decode JSON request body
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+	email, err := normalizeEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", err)
+		return
+	}
+
+	// every request can send an email, so every request counts and none are
+	// given back. The address is limited whether or not it has an account,
+	// so a 429 doesn't tell anyone which emails are registered.
+	if !reserveAttempt(w, "Too many login links requested, try again later",
+		cfg.magicLinkThrottle, loginAccountKey(email), cfg.magicLinkIPThrottle, loginIPKey(r)) {
+		return
+	}
+
+	// the link only works together with this token, so a link forwarded or
+	// intercepted on its way through email can't be used from another device
+	requestToken := auth.MakeRefreshToken()
+
+	// always answer the same way so this endpoint can't be used to find out
+	// which emails have accounts. The email is sent after responding, how long
+	// sending takes would give it away otherwise.
+	user, err := cfg.dbQueries.GetUserByEmail(r.Context(), email)
+	if err == nil {
+		ctx := context.WithoutCancel(r.Context())
+		go func() {
+			err := cfg.sendMagicLinkEmail(ctx, user, requestToken)
+			if err != nil {
+				log.Printf("Error sending magic link email: %s", err)
+			}
+		}()
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkCookie,
+		Value:    requestToken,
+		Path:     "/api/login/magic",
+		MaxAge:   int(magicLinkDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.cookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	respondWithJSON(w, http.StatusAccepted, response{
+		RequestToken: requestToken,
+	})
+}
+
+func (cfg *apiConfig) sendMagicLinkEmail(ctx context.Context, user database.User, requestToken string) error {
+	token, err := auth.MakePurposeJWT(user.ID, auth.PurposeMagicLink, requestToken, cfg.jwtKeys, magicLinkDuration)
+	if err != nil {
+		return err
+	}
+
+	// the link is signed, the table only makes sure it is used once
+	err = cfg.dbQueries.CreateMagicLink(ctx, database.CreateMagicLinkParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(magicLinkDuration),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := cfg.appURL + "/magic-login?token=" + url.QueryEscape(token)
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy login link",
+		Body: fmt.Sprintf("Someone asked to log in to your Chirpy account without a password.\n\n"+
+			"Follow this link on the same device to log in:\n\n%s\n\n"+
+			"The link expires in 10 minutes and can only be used once. If you didn't ask for this, you can ignore this email.\n", link),
+	})
+}
+
+func (cfg *apiConfig) handlerLoginMagicLink(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token        string `json:"token"`
+		RequestToken string `json:"request_token"`
+		UseCookies   bool   `json:"use_cookies"`
+	}
+
+	// decode JSON request body
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	// browsers send the request token back in the cookie, other clients in the body
+	requestToken := params.RequestToken
+	if requestToken == "" {
+		if c, err := r.Cookie(magicLinkCookie); err == nil {
+			requestToken = c.Value
+		}
+	}
+
+	link, err := auth.ValidatePurposeJWT(params.Token, auth.PurposeMagicLink, cfg.jwtKeys)
+	if err != nil || requestToken == "" || !link.IsBoundTo(requestToken) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired login link", err)
+		return
+	}
+
+	_, err = cfg.dbQueries.ConsumeMagicLink(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired login link", err)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), link.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired login link", err)
+		return
+	}
+
+	// following the link proves the user can read mail sent to the address
+	if !user.EmailVerified {
+		user, err = cfg.dbQueries.MarkEmailVerified(r.Context(), user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   magicLinkCookie,
+		Path:   "/api/login/magic",
+		MaxAge: -1,
+	})
+
+	// the link stands in for the password, not for the second factor
+	if user.TotpEnabled {
+		cfg.respondWithMFAChallenge(w, r, user)
+		return
+	}
+
+	cfg.recordOwnerEvent(r, user.ID, eventLogin, "magic link")
+	cfg.respondWithNewSession(w, r, user, params.UseCookies)
+}
@@ -1,24 +1,54 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"runtime"
 	"sync/atomic"
+	"time"
 
+	"github.com/cryptidcodes/chirpy/internal/auth"
 	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/cryptidcodes/chirpy/internal/mailer"
+	"github.com/cryptidcodes/chirpy/internal/throttle"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	dbQueries      *database.Queries
-	platform       string
-	secretKey      string
-	polkaKey       string
+	fileserverHits  atomic.Int32
+	db              *sql.DB
+	dbQueries       *database.Queries
+	platform        string
+	jwtKeys         *auth.KeySet
+	polkaKey        string
+	adminKey        string
+	mailer          mailer.Mailer
+	appURL          string
+	accountThrottle *throttle.Limiter
+	ipThrottle      *throttle.Limiter
+	// magic link requests send mail, they are limited per address and per client IP
+	magicLinkThrottle   *throttle.Limiter
+	magicLinkIPThrottle *throttle.Limiter
+	// password reset requests send email too and are limited the same way
+	passwordResetThrottle   *throttle.Limiter
+	passwordResetIPThrottle *throttle.Limiter
+	hasher                  *auth.Hasher
+	passwordPolicy          *auth.PasswordPolicy
+	tokenDenylist           *tokenDenylist
+	cookieSecure            bool
+	// deleted accounts are kept this long before they are purged for good
+	deletionGracePeriod time.Duration
+	// registrationMode is open, invite or closed
+	registrationMode string
+	// chirpEditWindow is how long after posting a chirp can still be edited
+	chirpEditWindow time.Duration
+	// allowedReactions is the set of reactions chirps can get
+	allowedReactions map[string]bool
 }
 
 func main() {
@@ -35,15 +65,134 @@ func main() {
 	if platform == "" {
 		log.Fatal("PLATFORM must be set")
 	}
-	secretKey := os.Getenv("SECRET_KEY")
-	if secretKey == "" {
-		log.Fatal("SECRET_KEY must be set")
+	// sign JWTs with the PEM keys in JWT_KEYS_DIR, or fall back to HS256 with SECRET_KEY
+	var jwtKeys *auth.KeySet
+	if keysDir := os.Getenv("JWT_KEYS_DIR"); keysDir != "" {
+		keys, err := auth.LoadKeySet(keysDir, os.Getenv("JWT_SIGNING_KEY_ID"))
+		if err != nil {
+			log.Fatal("Error loading JWT keys: ", err)
+		}
+		jwtKeys = keys
+	} else {
+		secretKey := os.Getenv("SECRET_KEY")
+		if secretKey == "" {
+			log.Fatal("SECRET_KEY or JWT_KEYS_DIR must be set")
+		}
+		jwtKeys = auth.NewHMACKeySet(secretKey)
 	}
 	polkaKey := os.Getenv("POLKA_KEY")
 	if polkaKey == "" {
 		log.Fatal("POLKA_KEY must be set")
 	}
 
+	// the admin key is optional, admin users can always use the admin endpoints
+	adminKey := os.Getenv("ADMIN_KEY")
+
+	// failed logins are throttled per account and, with more headroom, per client IP
+	accountThrottle := throttle.New(throttle.Config{
+		FreeAttempts:     envInt("LOGIN_FREE_ATTEMPTS", 3),
+		BaseDelay:        envDuration("LOGIN_BASE_DELAY", time.Second),
+		MaxDelay:         envDuration("LOGIN_MAX_DELAY", time.Minute),
+		LockoutThreshold: envInt("LOGIN_LOCKOUT_THRESHOLD", 10),
+		LockoutDuration:  envDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		Window:           envDuration("LOGIN_FAILURE_WINDOW", time.Hour),
+	})
+	ipThrottle := throttle.New(throttle.Config{
+		FreeAttempts:     envInt("LOGIN_IP_FREE_ATTEMPTS", 20),
+		BaseDelay:        envDuration("LOGIN_BASE_DELAY", time.Second),
+		MaxDelay:         envDuration("LOGIN_MAX_DELAY", time.Minute),
+		LockoutThreshold: envInt("LOGIN_IP_LOCKOUT_THRESHOLD", 100),
+		LockoutDuration:  envDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		Window:           envDuration("LOGIN_FAILURE_WINDOW", time.Hour),
+	})
+
+	// login links are limited so the endpoint can't be used to flood someone's inbox
+	magicLinkThrottle := throttle.New(throttle.Config{
+		FreeAttempts: envInt("MAGIC_LINK_FREE_REQUESTS", 3),
+		BaseDelay:    envDuration("MAGIC_LINK_BASE_DELAY", time.Minute),
+		MaxDelay:     envDuration("MAGIC_LINK_MAX_DELAY", time.Hour),
+		Window:       envDuration("MAGIC_LINK_WINDOW", time.Hour),
+	})
+	magicLinkIPThrottle := throttle.New(throttle.Config{
+		FreeAttempts: envInt("MAGIC_LINK_IP_FREE_REQUESTS", 20),
+		BaseDelay:    envDuration("MAGIC_LINK_BASE_DELAY", time.Minute),
+		MaxDelay:     envDuration("MAGIC_LINK_MAX_DELAY", time.Hour),
+		Window:       envDuration("MAGIC_LINK_WINDOW", time.Hour),
+	})
+	passwordResetThrottle := throttle.New(throttle.Config{
+		FreeAttempts: envInt("PASSWORD_RESET_FREE_REQUESTS", 3),
+		BaseDelay:    envDuration("PASSWORD_RESET_BASE_DELAY", time.Minute),
+		MaxDelay:     envDuration("PASSWORD_RESET_MAX_DELAY", time.Hour),
+		Window:       envDuration("PASSWORD_RESET_WINDOW", time.Hour),
+	})
+	passwordResetIPThrottle := throttle.New(throttle.Config{
+		FreeAttempts: envInt("PASSWORD_RESET_IP_FREE_REQUESTS", 20),
+		BaseDelay:    envDuration("PASSWORD_RESET_BASE_DELAY", time.Minute),
+		MaxDelay:     envDuration("PASSWORD_RESET_MAX_DELAY", time.Hour),
+		Window:       envDuration("PASSWORD_RESET_WINDOW", time.Hour),
+	})
+
+	// password hashing is tunable, stored hashes are upgraded to new settings as users log in.
+	// Each hash holds ARGON2_MEMORY_KIB of memory, so only a few run at once.
+	hashParams, err := hashParamsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	hasher := auth.NewHasher(hashParams, envInt("HASH_MAX_CONCURRENCY", runtime.NumCPU()), envDuration("HASH_QUEUE_TIMEOUT", 2*time.Second))
+
+	// the breached password list is optional and only read once at startup
+	passwordPolicy := &auth.PasswordPolicy{
+		MinLength: envInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength: envInt("PASSWORD_MAX_LENGTH", 128),
+	}
+	if breachedFile := os.Getenv("BREACHED_PASSWORDS_FILE"); breachedFile != "" {
+		breached, err := auth.LoadBreachedPasswords(breachedFile)
+		if err != nil {
+			log.Fatal("Error loading breached passwords: ", err)
+		}
+		log.Printf("Loaded %d breached password hashes", breached.Len())
+		passwordPolicy.Breached = breached
+	}
+
+	// send mail through SMTP if it is configured, otherwise write it to an outbox directory
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "Chirpy <noreply@chirpy.local>"
+	}
+	var mail mailer.Mailer
+	if smtpHost := os.Getenv("SMTP_HOST"); smtpHost != "" {
+		smtpPort := os.Getenv("SMTP_PORT")
+		if smtpPort == "" {
+			smtpPort = "587"
+		}
+		mail = mailer.NewSMTPMailer(smtpHost, smtpPort, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), mailFrom)
+	} else {
+		outboxDir := os.Getenv("MAIL_OUTBOX_DIR")
+		if outboxDir == "" {
+			outboxDir = "outbox"
+		}
+		outbox, err := mailer.NewOutboxMailer(outboxDir, mailFrom)
+		if err != nil {
+			log.Fatal("Error creating mail outbox: ", err)
+		}
+		mail = outbox
+	}
+	// links in emails point at the frontend
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "http://localhost:" + port + "/app"
+	}
+
+	registrationMode, err := registrationModeFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	allowedReactions, err := reactionsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// connect to the database
 	db, err := sql.Open("postgres", dbURL)
 	if err != nil {
@@ -52,15 +201,45 @@ func main() {
 
 	dbQueries := database.New(db)
 
+	// load access tokens revoked by logouts, then keep checking for new ones from other instances
+	denylist := newTokenDenylist(dbQueries)
+	err = denylist.sync(context.Background())
+	if err != nil {
+		log.Fatal("Error loading token denylist: ", err)
+	}
+	go denylist.run(envDuration("TOKEN_DENYLIST_SYNC_INTERVAL", 10*time.Second))
+
 	// init config struct
 	cfg := apiConfig{
-		fileserverHits: atomic.Int32{},
-		dbQueries:      dbQueries,
-		platform:       platform,
-		secretKey:      secretKey,
-		polkaKey:       polkaKey,
+		fileserverHits:          atomic.Int32{},
+		db:                      db,
+		dbQueries:               dbQueries,
+		platform:                platform,
+		jwtKeys:                 jwtKeys,
+		polkaKey:                polkaKey,
+		adminKey:                adminKey,
+		mailer:                  mail,
+		appURL:                  appURL,
+		accountThrottle:         accountThrottle,
+		ipThrottle:              ipThrottle,
+		magicLinkThrottle:       magicLinkThrottle,
+		magicLinkIPThrottle:     magicLinkIPThrottle,
+		passwordResetThrottle:   passwordResetThrottle,
+		passwordResetIPThrottle: passwordResetIPThrottle,
+		hasher:                  hasher,
+		passwordPolicy:          passwordPolicy,
+		tokenDenylist:           denylist,
+		// browsers only send Secure cookies over HTTPS (and to localhost)
+		cookieSecure:        envBool("COOKIE_SECURE", true),
+		deletionGracePeriod: envDuration("ACCOUNT_DELETION_GRACE_PERIOD", 30*24*time.Hour),
+		registrationMode:    registrationMode,
+		chirpEditWindow:     envDuration("CHIRP_EDIT_WINDOW", 15*time.Minute),
+		allowedReactions:    allowedReactions,
 	}
 
+	// accounts deleted by their users are purged once the grace period is over
+	go cfg.purgeDeletedAccounts(envDuration("ACCOUNT_PURGE_INTERVAL", time.Hour))
+
 	// create a new http.ServeMux to handle requests
 	mux := http.NewServeMux()
 
@@ -70,25 +249,79 @@ func main() {
 
 	// API endpoint handlers
 	mux.HandleFunc("POST /api/users", cfg.handlerCreateUser)
-	mux.HandleFunc("PUT /api/users", cfg.handlerUpdateCredentials)
+	mux.Handle("PATCH /api/users", cfg.requireAuth(cfg.handlerUpdateUser))
+	// PUT predates partial updates and now behaves the same as PATCH
+	mux.Handle("PUT /api/users", cfg.requireAuth(cfg.handlerUpdateUser))
+	mux.HandleFunc("POST /api/users/email/confirm", cfg.handlerConfirmEmailChange)
+	mux.HandleFunc("POST /api/users/verify", cfg.handlerVerifyEmail)
+	mux.HandleFunc("POST /api/users/restore", cfg.handlerRestoreAccount)
+	mux.Handle("POST /api/users/verify/resend", cfg.requireAuth(cfg.handlerResendVerification))
+	mux.HandleFunc("POST /api/password/forgot", cfg.handlerForgotPassword)
+	mux.HandleFunc("POST /api/password/reset", cfg.handlerResetPassword)
 	mux.HandleFunc("POST /api/login", cfg.handlerLoginUser)
+	mux.HandleFunc("POST /api/login/2fa", cfg.handlerLoginTOTP)
+	mux.HandleFunc("POST /api/login/magic", cfg.handlerRequestMagicLink)
+	mux.HandleFunc("POST /api/login/magic/verify", cfg.handlerLoginMagicLink)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.Handle("POST /api/logout", cfg.requireAuth(cfg.handlerLogout))
+	mux.Handle("POST /api/oauth/clients", cfg.requireAuth(cfg.handlerCreateOAuthClient))
+	mux.Handle("GET /api/oauth/clients", cfg.requireAuth(cfg.handlerListOAuthClients))
+	mux.Handle("DELETE /api/oauth/clients/{clientID}", cfg.requireAuth(cfg.handlerDeleteOAuthClient))
+	mux.Handle("GET /api/me/security-events", cfg.requireAuth(cfg.handlerListSecurityEvents))
+	mux.Handle("GET /api/me/export", cfg.requireAuth(cfg.handlerExportAccount))
+	mux.Handle("DELETE /api/me", cfg.requireAuth(cfg.handlerDeleteAccount))
+	mux.Handle("POST /api/invites", cfg.requireAuth(cfg.handlerCreateInvite))
+	mux.Handle("GET /api/invites", cfg.requireAuth(cfg.handlerListInvites))
+	mux.Handle("DELETE /api/invites/{inviteID}", cfg.requireAuth(cfg.handlerRevokeInvite))
+	mux.Handle("GET /api/sessions", cfg.requireAuth(cfg.handlerListSessions))
+	mux.Handle("DELETE /api/sessions/{sessionID}", cfg.requireAuth(cfg.handlerRevokeSession))
+	mux.HandleFunc("POST /api/sessions/revoke-others", cfg.handlerRevokeOtherSessions)
+	mux.Handle("POST /api/tokens", cfg.requireAuth(cfg.handlerCreatePersonalAccessToken))
+	mux.Handle("GET /api/tokens", cfg.requireAuth(cfg.handlerListPersonalAccessTokens))
+	mux.Handle("DELETE /api/tokens/{tokenID}", cfg.requireAuth(cfg.handlerRevokePersonalAccessToken))
+	mux.Handle("POST /api/2fa/enroll", cfg.requireAuth(cfg.handlerEnrollTOTP))
+	mux.Handle("POST /api/2fa/confirm", cfg.requireAuth(cfg.handlerConfirmTOTP))
+	mux.Handle("POST /api/2fa/disable", cfg.requireAuth(cfg.handlerDisableTOTP))
 
-	mux.HandleFunc("POST /api/chirps", cfg.handlerCreateChirp)
+	mux.Handle("POST /api/chirps", cfg.requireScope(auth.ScopeChirpsWrite, cfg.handlerCreateChirp))
 	mux.HandleFunc("GET /api/chirps", cfg.handlerGetAllChirps)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.handlerGetChirpByID)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.handlerDeleteChirp)
+	mux.Handle("PUT /api/chirps/{chirpID}", cfg.requireScope(auth.ScopeChirpsWrite, cfg.handlerEditChirp))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/history", cfg.handlerGetChirpHistory)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", cfg.handlerGetChirpThread)
+	mux.Handle("POST /api/chirps/{chirpID}/rechirp", cfg.requireScope(auth.ScopeChirpsWrite, cfg.handlerRechirp))
+	mux.Handle("DELETE /api/chirps/{chirpID}/rechirp", cfg.requireScope(auth.ScopeChirpsWrite, cfg.handlerUndoRechirp))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/rechirps", cfg.handlerListRechirps)
+	mux.Handle("PUT /api/chirps/{chirpID}/reactions/{emoji}", cfg.requireScope(auth.ScopeChirpsWrite, cfg.handlerAddReaction))
+	mux.Handle("DELETE /api/chirps/{chirpID}/reactions/{emoji}", cfg.requireScope(auth.ScopeChirpsWrite, cfg.handlerRemoveReaction))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/reactions/{emoji}", cfg.handlerListReactions)
+	mux.Handle("DELETE /api/chirps/{chirpID}", cfg.requireScope(auth.ScopeChirpsWrite, cfg.handlerDeleteChirp))
 
 	// additional endpoint handlers
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
 
 	// webhook endpoint handlers
 	mux.HandleFunc("POST /api/polka/webhooks", cfg.handlerUpgradeToChirpyRed)
 
+	// OAuth2 authorization server for third-party clients
+	mux.Handle("GET /oauth/authorize", cfg.optionalAuth(cfg.handlerAuthorize))
+	mux.Handle("POST /oauth/authorize", cfg.optionalAuth(cfg.handlerAuthorizeDecision))
+	mux.HandleFunc("POST /oauth/token", cfg.handlerOAuthToken)
+	mux.HandleFunc("POST /oauth/introspect", cfg.handlerOAuthIntrospect)
+	mux.HandleFunc("POST /oauth/revoke", cfg.handlerOAuthRevoke)
+
 	// admin endpoint handlers
-	mux.HandleFunc("GET /admin/metrics", cfg.handlerMetrics)
-	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.Handle("GET /admin/metrics", cfg.requireAdmin(cfg.handlerMetrics))
+	mux.Handle("POST /admin/reset", cfg.requireAdmin(cfg.handlerReset))
+	mux.Handle("POST /admin/users/unlock", cfg.requireAdmin(cfg.handlerUnlockAccount))
+	mux.Handle("PUT /admin/users/{userID}/role", cfg.requireAdmin(cfg.handlerSetUserRole))
+	mux.Handle("GET /admin/audit-events", cfg.requireAdmin(cfg.handlerAdminListAuditEvents))
+	mux.Handle("POST /admin/invites", cfg.requireAdmin(cfg.handlerCreateInvite))
+	mux.Handle("GET /admin/invites", cfg.requireAdmin(cfg.handlerAdminListInvites))
+	mux.Handle("DELETE /admin/invites/{inviteID}", cfg.requireAdmin(cfg.handlerRevokeInvite))
+	mux.Handle("GET /admin/invites/{inviteID}/redemptions", cfg.requireAdmin(cfg.handlerListInviteRedemptions))
 
 	// create a new http.Server struct
 	server := &http.Server{
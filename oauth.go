@@ -0,0 +1,483 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cryptidcodes/chirpy/internal/auth"
+	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// authorization codes have to be traded for tokens right away
+const authorizationCodeDuration = 5 * time.Minute
+
+// what each scope lets a client do, as shown on the consent page
+var scopeDescriptions = map[string]string{
+	auth.ScopeChirpsWrite: "Post and delete chirps as you",
+}
+
+// authorizeRequest is a validated request from /oauth/authorize
+type authorizeRequest struct {
+	Client        database.OauthClient
+	RedirectURI   string
+	State         string
+	Scopes        []string
+	CodeChallenge string
+}
+
+// parseAuthorizeRequest validates the parameters of an authorization request.
+// Until the client and redirect URI are known to be good, errors can only be
+// shown to the user (pageErr). After that they go back to the client (redirectErr).
+func (cfg *apiConfig) parseAuthorizeRequest(r *http.Request) (req authorizeRequest, pageErr, redirectErr string) {
+	clientID, err := uuid.Parse(r.FormValue("client_id"))
+	if err != nil {
+		return req, "Unknown client", ""
+	}
+	client, err := cfg.dbQueries.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return req, "Unknown client", ""
+	}
+	req.Client = client
+
+	// the redirect URI has to match a registered one exactly
+	req.RedirectURI = r.FormValue("redirect_uri")
+	if req.RedirectURI == "" && len(client.RedirectUris) == 1 {
+		req.RedirectURI = client.RedirectUris[0]
+	}
+	if !slices.Contains(client.RedirectUris, req.RedirectURI) {
+		return req, "Invalid redirect URI", ""
+	}
+	req.State = r.FormValue("state")
+
+	if r.FormValue("response_type") != "code" {
+		return req, "", "unsupported_response_type"
+	}
+	// PKCE is required of every client, confidential or not
+	req.CodeChallenge = r.FormValue("code_challenge")
+	if req.CodeChallenge == "" || r.FormValue("code_challenge_method") != auth.PKCEMethodS256 {
+		return req, "", "invalid_request"
+	}
+
+	// clients get the scopes they registered for unless they ask for fewer
+	req.Scopes = strings.Fields(r.FormValue("scope"))
+	if len(req.Scopes) == 0 {
+		req.Scopes = client.Scopes
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return req, "", "invalid_scope"
+		}
+	}
+
+	return req, "", ""
+}
+
+// redirectToClient sends the browser back to the client with the given query parameters
+func redirectToClient(w http.ResponseWriter, r *http.Request, req authorizeRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		renderOAuthPage(w, http.StatusBadRequest, oauthErrorPage, "Invalid redirect URI")
+		return
+	}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func (cfg *apiConfig) handlerAuthorize(w http.ResponseWriter, r *http.Request) {
+	type consent struct {
+		ClientName string
+		Scopes     []string
+		Form       url.Values
+		CSRFToken  string
+	}
+
+	req, pageErr, redirectErr := cfg.parseAuthorizeRequest(r)
+	if pageErr != "" {
+		renderOAuthPage(w, http.StatusBadRequest, oauthErrorPage, pageErr)
+		return
+	}
+	if redirectErr != "" {
+		redirectToClient(w, r, req, url.Values{"error": {redirectErr}})
+		return
+	}
+
+	// the consent page is only for users logged in to the frontend with a browser session
+	p := principalFromContext(r.Context())
+	csrf, err := r.Cookie(csrfCookie)
+	if p == nil || p.Scopes != nil || p.AdminKey || err != nil {
+		renderOAuthPage(w, http.StatusUnauthorized, oauthErrorPage, "Log in to Chirpy first, then try again")
+		return
+	}
+
+	// everything the POST needs to check the request again
+	form := url.Values{
+		"client_id":             {req.Client.ID.String()},
+		"redirect_uri":          {req.RedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {strings.Join(req.Scopes, " ")},
+		"state":                 {req.State},
+		"code_challenge":        {req.CodeChallenge},
+		"code_challenge_method": {auth.PKCEMethodS256},
+	}
+	descriptions := make([]string, len(req.Scopes))
+	for i, scope := range req.Scopes {
+		descriptions[i] = scopeDescriptions[scope]
+	}
+	renderOAuthPage(w, http.StatusOK, oauthConsentPage, consent{
+		ClientName: req.Client.Name,
+		Scopes:     descriptions,
+		Form:       form,
+		CSRFToken:  csrf.Value,
+	})
+}
+
+func (cfg *apiConfig) handlerAuthorizeDecision(w http.ResponseWriter, r *http.Request) {
+	req, pageErr, redirectErr := cfg.parseAuthorizeRequest(r)
+	if pageErr != "" {
+		renderOAuthPage(w, http.StatusBadRequest, oauthErrorPage, pageErr)
+		return
+	}
+	if redirectErr != "" {
+		redirectToClient(w, r, req, url.Values{"error": {redirectErr}})
+		return
+	}
+
+	// the middleware has already checked the CSRF token for cookie sessions
+	p := principalFromContext(r.Context())
+	if p == nil || p.Scopes != nil || p.AdminKey {
+		renderOAuthPage(w, http.StatusUnauthorized, oauthErrorPage, "Log in to Chirpy first, then try again")
+		return
+	}
+
+	if r.PostFormValue("decision") != "approve" {
+		redirectToClient(w, r, req, url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	code := auth.MakeRefreshToken()
+	err := cfg.dbQueries.CreateAuthorizationCode(r.Context(), database.CreateAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      req.Client.ID,
+		UserID:        p.UserID,
+		RedirectUri:   req.RedirectURI,
+		Scopes:        req.Scopes,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().Add(authorizationCodeDuration),
+	})
+	if err != nil {
+		log.Printf("Error storing authorization code: %s", err)
+		redirectToClient(w, r, req, url.Values{"error": {"server_error"}})
+		return
+	}
+
+	redirectToClient(w, r, req, url.Values{"code": {code}})
+}
+
+// respondWithOAuthError uses the error format of RFC 6749 rather than our own
+func respondWithOAuthError(w http.ResponseWriter, code int, errCode, description string) {
+	type errorResponse struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}
+
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+	respondWithJSON(w, code, errorResponse{
+		Error:            errCode,
+		ErrorDescription: description,
+	})
+}
+
+// authenticateOAuthClient identifies the client calling a back-channel
+// endpoint. Confidential clients send their secret with HTTP Basic auth or in
+// the form, public clients only send their ID.
+func (cfg *apiConfig) authenticateOAuthClient(r *http.Request) (database.OauthClient, error) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		// RFC 6749 has the credentials form-encoded before they are put in the header
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+
+	clientID, err := uuid.Parse(id)
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+	client, err := cfg.dbQueries.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+	if client.SecretHash.Valid &&
+		subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash.String)) != 1 {
+		return database.OauthClient{}, errors.New("invalid client secret")
+	}
+	return client, nil
+}
+
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, r *http.Request) {
+	// token responses must never be cached
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	client, err := cfg.authenticateOAuthClient(r)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		cfg.grantAuthorizationCode(w, r, client)
+	case "refresh_token":
+		cfg.grantRefreshToken(w, r, client)
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+}
+
+func (cfg *apiConfig) grantAuthorizationCode(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	// codes are used up even if the rest of the request is wrong, so a stolen code only gets one try
+	code, err := cfg.dbQueries.ConsumeAuthorizationCode(r.Context(), auth.HashToken(r.PostFormValue("code")))
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid or expired authorization code")
+		return
+	}
+	if code.ClientID != client.ID || code.RedirectUri != r.PostFormValue("redirect_uri") {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Authorization code was issued to another client or redirect URI")
+		return
+	}
+	if !auth.VerifyPKCE(r.PostFormValue("code_verifier"), code.CodeChallenge) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid code verifier")
+		return
+	}
+
+	// every authorization starts a new refresh token family, like a login
+	refreshToken := auth.MakeRefreshToken()
+	_, err = cfg.dbQueries.StoreRefreshToken(r.Context(), database.StoreRefreshTokenParams{
+		Token:     refreshToken,
+		UserID:    code.UserID,
+		ExpiresAt: time.Now().Add(refreshTokenDuration),
+		FamilyID:  uuid.New(),
+		UserAgent: userAgent(r),
+		IpAddress: clientIP(r),
+		ClientID:  uuid.NullUUID{UUID: client.ID, Valid: true},
+		Scopes:    code.Scopes,
+	})
+	if err != nil {
+		log.Printf("Error storing refresh token: %s", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	cfg.respondWithOAuthTokens(w, code.UserID, client.ID, code.Scopes, refreshToken)
+}
+
+func (cfg *apiConfig) grantRefreshToken(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	refreshToken := r.PostFormValue("refresh_token")
+	stored, err := cfg.dbQueries.GetRefreshToken(r.Context(), refreshToken)
+	if err != nil || !stored.ClientID.Valid || stored.ClientID.UUID != client.ID {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+		return
+	}
+
+	// same rotation and reuse detection as /api/refresh
+	if stored.RevokedAt.Valid {
+		cfg.revokeReusedOAuthFamily(w, r, stored.FamilyID)
+		return
+	}
+	newRefreshToken := auth.MakeRefreshToken()
+	err = cfg.rotateRefreshToken(r, stored, newRefreshToken)
+	if errors.Is(err, sql.ErrNoRows) {
+		current, err := cfg.dbQueries.GetRefreshToken(r.Context(), refreshToken)
+		if err == nil && current.RevokedAt.Valid {
+			cfg.revokeReusedOAuthFamily(w, r, stored.FamilyID)
+			return
+		}
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+		return
+	}
+	if err != nil {
+		log.Printf("Error rotating refresh token: %s", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	cfg.respondWithOAuthTokens(w, stored.UserID, client.ID, stored.Scopes, newRefreshToken)
+}
+
+func (cfg *apiConfig) revokeReusedOAuthFamily(w http.ResponseWriter, r *http.Request, familyID uuid.UUID) {
+	err := cfg.dbQueries.RevokeRefreshTokenFamily(r.Context(), familyID)
+	if err != nil {
+		log.Printf("Error revoking refresh token family: %s", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Refresh token has already been used")
+}
+
+func (cfg *apiConfig) respondWithOAuthTokens(w http.ResponseWriter, userID, clientID uuid.UUID, scopes []string, refreshToken string) {
+	type response struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+
+	accessToken, err := auth.MakeScopedJWT(userID, clientID.String(), scopes, cfg.jwtKeys, accessTokenDuration)
+	if err != nil {
+		log.Printf("Error creating JWT: %s", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenDuration.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	})
+}
+
+func (cfg *apiConfig) handlerOAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientID  string `json:"client_id,omitempty"`
+		Subject   string `json:"sub,omitempty"`
+		ExpiresAt int64  `json:"exp,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+	}
+
+	client, err := cfg.authenticateOAuthClient(r)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	// clients can only look at their own tokens, anything else is simply inactive
+	token := r.PostFormValue("token")
+	if accessToken, err := auth.ValidateJWT(token, cfg.jwtKeys); err == nil {
+		// tokens outlive a deleted account until they expire, the account has to still be there
+		_, userErr := cfg.dbQueries.GetUserByID(r.Context(), accessToken.UserID)
+		if accessToken.ClientID == client.ID.String() && !cfg.tokenDenylist.Contains(accessToken.ID) && userErr == nil {
+			respondWithJSON(w, http.StatusOK, response{
+				Active:    true,
+				Scope:     strings.Join(accessToken.Scopes, " "),
+				ClientID:  accessToken.ClientID,
+				Subject:   accessToken.UserID.String(),
+				ExpiresAt: accessToken.ExpiresAt.Unix(),
+				TokenType: "access_token",
+			})
+			return
+		}
+	} else if stored, err := cfg.dbQueries.GetLiveRefreshToken(r.Context(), token); err == nil {
+		if stored.ClientID.Valid && stored.ClientID.UUID == client.ID {
+			respondWithJSON(w, http.StatusOK, response{
+				Active:    true,
+				Scope:     strings.Join(stored.Scopes, " "),
+				ClientID:  client.ID.String(),
+				Subject:   stored.UserID.String(),
+				ExpiresAt: stored.ExpiresAt.Unix(),
+				TokenType: "refresh_token",
+			})
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, response{Active: false})
+}
+
+func (cfg *apiConfig) handlerOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	client, err := cfg.authenticateOAuthClient(r)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	// per RFC 7009 unknown tokens and tokens of other clients are not an error
+	token := r.PostFormValue("token")
+	if accessToken, jwtErr := auth.ValidateJWT(token, cfg.jwtKeys); jwtErr == nil {
+		if accessToken.ClientID == client.ID.String() {
+			err = cfg.tokenDenylist.Revoke(r.Context(), accessToken.ID, accessToken.UserID, accessToken.ExpiresAt)
+		}
+	} else if stored, dbErr := cfg.dbQueries.GetRefreshToken(r.Context(), token); dbErr == nil {
+		// revoking a refresh token ends the whole grant
+		if stored.ClientID.Valid && stored.ClientID.UUID == client.ID {
+			err = cfg.dbQueries.RevokeRefreshTokenFamily(r.Context(), stored.FamilyID)
+		}
+	}
+	if err != nil {
+		log.Printf("Error revoking token: %s", err)
+		respondWithOAuthError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+var (
+	oauthErrorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Chirpy</title></head>
+<body>
+<h1>Chirpy</h1>
+<p>{{.}}</p>
+<p><a href="/app/">Go to Chirpy</a></p>
+</body>
+</html>
+`))
+
+	oauthConsentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorize {{.ClientName}} - Chirpy</title></head>
+<body>
+<h1>Authorize {{.ClientName}}</h1>
+<p>{{.ClientName}} wants to use your Chirpy account to:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+<form method="POST" action="/oauth/authorize">
+{{range $name, $values := .Form}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">
+{{end}}{{end}}<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<button type="submit" name="decision" value="approve">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+)
+
+func renderOAuthPage(w http.ResponseWriter, code int, page *template.Template, data any) {
+	// the consent page must never be framed, or another site could trick users into clicking Allow
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	err := page.Execute(w, data)
+	if err != nil {
+		log.Printf("Error rendering page: %s", err)
+	}
+}
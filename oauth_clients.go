@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cryptidcodes/chirpy/internal/auth"
+	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// DO NOT DELETE: USED IN RESPONSE STRUCTURES
+// database.OauthClient DOES NOT HAVE JSON TAGS
+type OAuthClient struct {
+	ID           uuid.UUID `json:"client_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Confidential bool      `json:"confidential"`
+}
+
+func oauthClientFromDB(client database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ID:           client.ID,
+		CreatedAt:    client.CreatedAt,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectUris,
+		Scopes:       client.Scopes,
+		Confidential: client.SecretHash.Valid,
+	}
+}
+
+func (cfg *apiConfig) handlerCreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Confidential bool     `json:"confidential"`
+	}
+	type response struct {
+		OAuthClient
+		ClientSecret string `json:"client_secret,omitempty"`
+	}
+
+	userID := principalFromContext(r.Context()).UserID
+
+	// decode JSON request body
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Client name is required", nil)
+		return
+	}
+	if len(params.RedirectURIs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one redirect URI is required", nil)
+		return
+	}
+	for _, redirectURI := range params.RedirectURIs {
+		if !validRedirectURI(redirectURI) {
+			respondWithError(w, http.StatusBadRequest, "Invalid redirect URI: "+redirectURI, nil)
+			return
+		}
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required", nil)
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !auth.ValidScope(scope) {
+			respondWithError(w, http.StatusBadRequest, "Unknown scope: "+scope, nil)
+			return
+		}
+	}
+
+	// confidential clients run on a server and can keep a secret, public ones
+	// (mobile and single page apps) rely on PKCE alone
+	secret := ""
+	secretHash := sql.NullString{}
+	if params.Confidential {
+		secret = auth.MakeClientSecret()
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	client, err := cfg.dbQueries.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		OwnerID:      userID,
+		Name:         params.Name,
+		SecretHash:   secretHash,
+		RedirectUris: params.RedirectURIs,
+		Scopes:       params.Scopes,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create client", err)
+		return
+	}
+
+	// only the hash of the secret is stored, this is the only time it is shown
+	respondWithJSON(w, http.StatusCreated, response{
+		OAuthClient:  oauthClientFromDB(client),
+		ClientSecret: secret,
+	})
+}
+
+// validRedirectURI accepts absolute URIs without a fragment. Plain http is
+// only allowed for loopback addresses, where apps on the user's own machine listen.
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	case "javascript", "data", "file", "vbscript":
+		return false
+	default:
+		// custom schemes like com.example.app:/callback for native apps
+		return true
+	}
+}
+
+func (cfg *apiConfig) handlerListOAuthClients(w http.ResponseWriter, r *http.Request) {
+	userID := principalFromContext(r.Context()).UserID
+
+	clients, err := cfg.dbQueries.ListOAuthClients(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve clients", err)
+		return
+	}
+
+	resp := make([]OAuthClient, len(clients))
+	for i := range clients {
+		resp[i] = oauthClientFromDB(clients[i])
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerDeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	userID := principalFromContext(r.Context()).UserID
+
+	// extract clientID from URL
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid client ID", err)
+		return
+	}
+
+	// deleting the client also deletes its codes and refresh tokens
+	deleted, err := cfg.dbQueries.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{
+		ID:      clientID,
+		OwnerID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete client", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Client not found", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
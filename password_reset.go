@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cryptidcodes/chirpy/internal/auth"
+	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/cryptidcodes/chirpy/internal/mailer"
+	"github.com/google/uuid"
+)
+
+// reset links are only good for half an hour
+const passwordResetDuration = 30 * time.Minute
+
+func (cfg *apiConfig) handlerForgotPassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	// decode JSON request body
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	// every request can send an email, so every request counts and none are
+	// given back. The address is limited whether or not it has an account,
+	// so a 429 doesn't tell anyone which emails are registered.
+	if !reserveAttempt(w, "Too many password resets requested, try again later",
+		cfg.passwordResetThrottle, loginAccountKey(params.Email), cfg.passwordResetIPThrottle, loginIPKey(r)) {
+		return
+	}
+
+	// always answer the same way so this endpoint can't be used to find out
+	// which emails have accounts. The email is sent after responding, how long
+	// sending takes would give it away otherwise.
+	user, err := cfg.dbQueries.GetUserByEmail(r.Context(), params.Email)
+	if err == nil {
+		ctx := context.WithoutCancel(r.Context())
+		go func() {
+			err := cfg.sendPasswordResetEmail(ctx, user)
+			if err != nil {
+				log.Printf("Error sending password reset email: %s", err)
+			}
+		}()
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) sendPasswordResetEmail(ctx context.Context, user database.User) error {
+	// only the hash is stored, the token itself only exists in the email
+	token := auth.MakeRefreshToken()
+	err := cfg.dbQueries.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(passwordResetDuration),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := cfg.appURL + "/reset-password?token=" + url.QueryEscape(token)
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\n"+
+			"Follow this link to choose a new password:\n\n%s\n\n"+
+			"The link expires in 30 minutes and can only be used once. If you didn't ask for this, you can ignore this email.\n", link),
+	})
+}
+
+func (cfg *apiConfig) handlerResetPassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	// decode JSON request body
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	// look the token up without using it, the new password may still be rejected
+	user, err := cfg.dbQueries.GetUserByPasswordResetToken(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token", err)
+		return
+	}
+	err = cfg.passwordPolicy.Check(params.Password, user.Email)
+	if err != nil {
+		respondWithPolicyError(w, err)
+		return
+	}
+
+	// hash the new password before touching the token so a slow hash doesn't hold the transaction open
+	hashedPW, err := cfg.hasher.HashPassword(r.Context(), params.Password)
+	if err != nil {
+		respondWithHashError(w, err)
+		return
+	}
+
+	userID, err := cfg.resetPassword(r.Context(), params.Token, hashedPW)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token", err)
+		return
+	}
+	cfg.recordOwnerEvent(r, userID, eventPasswordReset, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resetPassword consumes the reset token, sets the new password and logs the
+// user out of every session, all in one transaction
+func (cfg *apiConfig) resetPassword(ctx context.Context, token, hashedPassword string) (uuid.UUID, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	reset, err := qtx.ConsumePasswordResetToken(ctx, auth.HashToken(token))
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	err = qtx.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{
+		ID:             reset.UserID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	// any other reset links that are still out there are now stale
+	err = qtx.InvalidatePasswordResetTokens(ctx, reset.UserID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	err = qtx.RevokeAllRefreshTokensForUser(ctx, reset.UserID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return reset.UserID, tx.Commit()
+}
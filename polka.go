@@ -41,6 +41,7 @@ func (cfg *apiConfig) handlerUpgradeToChirpyRed(w http.ResponseWriter, r *http.R
 			http.Error(w, "Failed to upgrade user", http.StatusNotFound)
 			return
 		}
+		cfg.recordAuditEvent(r, "polka", uuid.NullUUID{}, params.Data.UserID, eventChirpyRedUpgraded, "")
 		respondWithJSON(w, http.StatusNoContent, nil)
 	}
 }
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// reactions allowed when CHIRP_REACTIONS isn't set
+const defaultChirpReactions = "👍,❤️,😂,😮,😢,🎉"
+
+// reactionsFromEnv reads the comma-separated set of allowed reactions from CHIRP_REACTIONS
+func reactionsFromEnv() (map[string]bool, error) {
+	list := os.Getenv("CHIRP_REACTIONS")
+	if list == "" {
+		list = defaultChirpReactions
+	}
+	allowed := map[string]bool{}
+	for _, emoji := range strings.Split(list, ",") {
+		emoji = strings.TrimSpace(emoji)
+		if emoji != "" {
+			allowed[emoji] = true
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, errors.New("CHIRP_REACTIONS must list at least one reaction")
+	}
+	return allowed, nil
+}
+
+// DO NOT DELETE: USED IN RESPONSE STRUCTURES
+// database.ChirpReaction DOES NOT HAVE JSON TAGS
+type Reaction struct {
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	Emoji     string    `json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func reactionFromDB(reaction database.ChirpReaction) Reaction {
+	return Reaction{
+		ChirpID:   reaction.ChirpID,
+		Emoji:     reaction.Emoji,
+		CreatedAt: reaction.CreatedAt,
+	}
+}
+
+// reactionCountsFromDB decodes the denormalized counts stored on a chirp
+func reactionCountsFromDB(raw json.RawMessage) map[string]int32 {
+	counts := map[string]int32{}
+	if len(raw) == 0 {
+		return counts
+	}
+	err := json.Unmarshal(raw, &counts)
+	if err != nil {
+		log.Printf("Error decoding reaction counts: %s", err)
+		return map[string]int32{}
+	}
+	return counts
+}
+
+// reactionTarget reads the chirp and emoji from the URL and checks that the
+// chirp can be reacted to. Rechirps and deleted chirps can't, react to the
+// original instead.
+func (cfg *apiConfig) reactionTarget(w http.ResponseWriter, r *http.Request) (uuid.UUID, string, bool) {
+	// extract chirpID from URL
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return uuid.Nil, "", false
+	}
+
+	emoji := r.PathValue("emoji")
+	if !cfg.allowedReactions[emoji] {
+		respondWithError(w, http.StatusBadRequest, "Reaction not allowed", nil)
+		return uuid.Nil, "", false
+	}
+
+	chirp, err := cfg.dbQueries.GetChirpByID(r.Context(), chirpID)
+	if err == nil && (chirp.DeletedAt.Valid || chirp.Kind == chirpKindRechirp) {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Chirp not found", err)
+		return uuid.Nil, "", false
+	}
+
+	return chirpID, emoji, true
+}
+
+// handlerAddReaction adds the caller's reaction to a chirp. Adding a reaction
+// the caller already gave does nothing.
+func (cfg *apiConfig) handlerAddReaction(w http.ResponseWriter, r *http.Request) {
+	caller := principalFromContext(r.Context())
+
+	// reacting is posting too, so it needs the same verified email address
+	if !caller.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "Email address must be verified before reacting to chirps", nil)
+		return
+	}
+
+	chirpID, emoji, ok := cfg.reactionTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.addReaction(r.Context(), chirpID, caller.UserID, emoji)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add reaction", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addReaction stores the reaction and bumps the chirp's count for it in one
+// transaction, the count only moves if the reaction is new
+func (cfg *apiConfig) addReaction(ctx context.Context, chirpID, userID uuid.UUID, emoji string) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	added, err := qtx.CreateReaction(ctx, database.CreateReactionParams{
+		ChirpID: chirpID,
+		UserID:  userID,
+		Emoji:   emoji,
+	})
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return nil
+	}
+
+	err = qtx.IncrementReactionCount(ctx, database.IncrementReactionCountParams{
+		Emoji: emoji,
+		ID:    chirpID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// handlerRemoveReaction takes the caller's reaction back. Removing a reaction
+// the caller never gave does nothing.
+func (cfg *apiConfig) handlerRemoveReaction(w http.ResponseWriter, r *http.Request) {
+	caller := principalFromContext(r.Context())
+
+	chirpID, emoji, ok := cfg.reactionTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.removeReaction(r.Context(), chirpID, caller.UserID, emoji)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove reaction", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) removeReaction(ctx context.Context, chirpID, userID uuid.UUID, emoji string) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	removed, err := qtx.DeleteReaction(ctx, database.DeleteReactionParams{
+		ChirpID: chirpID,
+		UserID:  userID,
+		Emoji:   emoji,
+	})
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return nil
+	}
+
+	err = qtx.DecrementReactionCount(ctx, database.DecrementReactionCountParams{
+		Emoji: emoji,
+		ID:    chirpID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// removeUserReactions takes back every reaction the user gave, for accounts
+// that are deleted. Their reactions would still be counted but never listed.
+func removeUserReactions(ctx context.Context, qtx *database.Queries, userID uuid.UUID) error {
+	reactions, err := qtx.ListReactionsByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, reaction := range reactions {
+		removed, err := qtx.DeleteReaction(ctx, database.DeleteReactionParams{
+			ChirpID: reaction.ChirpID,
+			UserID:  userID,
+			Emoji:   reaction.Emoji,
+		})
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			continue
+		}
+		err = qtx.DecrementReactionCount(ctx, database.DecrementReactionCountParams{
+			Emoji: reaction.Emoji,
+			ID:    reaction.ChirpID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handlerListReactions lists who gave a chirp one kind of reaction, newest
+// first, a page at a time
+func (cfg *apiConfig) handlerListReactions(w http.ResponseWriter, r *http.Request) {
+	type reaction struct {
+		UserID    uuid.UUID `json:"user_id"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	type response struct {
+		Reactions  []reaction `json:"reactions"`
+		NextCursor *string    `json:"next_cursor"`
+	}
+
+	chirpID, emoji, ok := cfg.reactionTarget(w, r)
+	if !ok {
+		return
+	}
+
+	// reactions are paged by who reacted rather than by chirp id
+	limit, cursorCreatedAt, cursorUserID, ok := chirpPageParams(w, r)
+	if !ok {
+		return
+	}
+	// one extra row tells us whether there is another page
+	params := database.ListReactionsByChirpParams{
+		ChirpID:         chirpID,
+		Emoji:           emoji,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorUserID:    cursorUserID,
+		MaxRows:         int32(limit + 1),
+	}
+
+	reactions, err := cfg.dbQueries.ListReactionsByChirp(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve reactions", err)
+		return
+	}
+
+	var nextCursor *string
+	if len(reactions) > limit {
+		reactions = reactions[:limit]
+		last := reactions[limit-1]
+		cursor := encodeChirpCursor(last.CreatedAt, last.UserID)
+		nextCursor = &cursor
+	}
+
+	resp := response{
+		Reactions:  make([]reaction, len(reactions)),
+		NextCursor: nextCursor,
+	}
+	for i := range reactions {
+		resp.Reactions[i] = reaction{
+			UserID:    reactions[i].UserID,
+			CreatedAt: reactions[i].CreatedAt,
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
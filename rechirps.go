@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerRechirp reshares someone's chirp as is. A rechirp is a chirp of its
+// own with no body that points at the original.
+func (cfg *apiConfig) handlerRechirp(w http.ResponseWriter, r *http.Request) {
+	caller := principalFromContext(r.Context())
+
+	// rechirping is posting, so it needs the same verified email address
+	if !caller.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "Email address must be verified before posting chirps", nil)
+		return
+	}
+
+	// extract chirpID from URL
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	rechirp, err := cfg.rechirp(r.Context(), caller.UserID, chirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found", err)
+		return
+	}
+	if isUniqueViolation(err) {
+		respondWithError(w, http.StatusConflict, "You have already rechirped this chirp", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rechirp chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, chirpFromDB(rechirp))
+}
+
+// rechirp creates the rechirp and bumps the original's count in one
+// transaction. sql.ErrNoRows means the original can't be rechirped, because
+// it doesn't exist, was deleted or is a rechirp itself.
+func (cfg *apiConfig) rechirp(ctx context.Context, userID, originalID uuid.UUID) (database.Chirp, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	updated, err := qtx.IncrementRechirpCount(ctx, originalID)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	if updated == 0 {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	// the unique index turns a second rechirp into a unique violation
+	rechirp, err := qtx.CreateChirp(ctx, database.CreateChirpParams{
+		UserID:     uuid.NullUUID{UUID: userID, Valid: true},
+		Kind:       chirpKindRechirp,
+		OriginalID: uuid.NullUUID{UUID: originalID, Valid: true},
+	})
+	if err != nil {
+		return database.Chirp{}, err
+	}
+
+	return rechirp, tx.Commit()
+}
+
+func (cfg *apiConfig) handlerUndoRechirp(w http.ResponseWriter, r *http.Request) {
+	caller := principalFromContext(r.Context())
+
+	// extract chirpID from URL
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	err = cfg.undoRechirp(r.Context(), caller.UserID, chirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "You haven't rechirped this chirp", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't undo rechirp", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) undoRechirp(ctx context.Context, userID, originalID uuid.UUID) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	_, err = qtx.DeleteRechirp(ctx, database.DeleteRechirpParams{
+		UserID:     uuid.NullUUID{UUID: userID, Valid: true},
+		OriginalID: uuid.NullUUID{UUID: originalID, Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = qtx.DecrementRechirpCount(ctx, originalID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// handlerListRechirps lists who rechirped a chirp, newest first, a page at a time
+func (cfg *apiConfig) handlerListRechirps(w http.ResponseWriter, r *http.Request) {
+	type rechirp struct {
+		ID        uuid.UUID `json:"id"`
+		UserID    uuid.UUID `json:"user_id"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	type response struct {
+		Rechirps   []rechirp `json:"rechirps"`
+		NextCursor *string   `json:"next_cursor"`
+	}
+
+	// extract chirpID from URL
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	limit, cursorCreatedAt, cursorID, ok := chirpPageParams(w, r)
+	if !ok {
+		return
+	}
+	// one extra row tells us whether there is another page
+	params := database.ListRechirpsParams{
+		OriginalID:      uuid.NullUUID{UUID: chirpID, Valid: true},
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		MaxRows:         int32(limit + 1),
+	}
+
+	_, err = cfg.dbQueries.GetChirpByID(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't retrieve chirp", err)
+		return
+	}
+
+	rechirps, err := cfg.dbQueries.ListRechirps(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve rechirps", err)
+		return
+	}
+
+	var nextCursor *string
+	if len(rechirps) > limit {
+		rechirps = rechirps[:limit]
+		last := rechirps[limit-1]
+		cursor := encodeChirpCursor(last.CreatedAt, last.ID)
+		nextCursor = &cursor
+	}
+
+	resp := response{
+		Rechirps:   make([]rechirp, len(rechirps)),
+		NextCursor: nextCursor,
+	}
+	for i := range rechirps {
+		resp.Rechirps[i] = rechirp{
+			ID:        rechirps[i].ID,
+			UserID:    rechirps[i].UserID.UUID,
+			CreatedAt: rechirps[i].CreatedAt,
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -1,57 +1,162 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/cryptidcodes/chirpy/internal/auth"
+	"github.com/cryptidcodes/chirpy/internal/database"
 )
 
+// refresh tokens are valid for 60 days from the moment they are issued
+const refreshTokenDuration = 60 * 24 * time.Hour
+
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	// define request and response structures for this endpoint
 	type respToken struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
-	// get refresh token from request header
-	refreshToken, err := auth.GetBearerToken(r.Header)
+	// get refresh token from request header, or the cookie of a browser session
+	refreshToken, fromCookie, err := refreshTokenFromRequest(r)
+	if errors.Is(err, errInvalidCSRF) {
+		respondWithError(w, http.StatusForbidden, "Missing or invalid CSRF token", nil)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Invalid authorization header", err)
 		return
 	}
 
-	// get user ID associated with refresh token from database
-	user, err := cfg.dbQueries.GetUserByRefreshToken(r.Context(), refreshToken)
+	// look up the refresh token in the database
+	stored, err := cfg.dbQueries.GetRefreshToken(r.Context(), refreshToken)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", err)
 		return
 	}
 
+	// tokens issued to third-party clients are refreshed at /oauth/token with their scopes intact
+	if stored.ClientID.Valid {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", nil)
+		return
+	}
+
+	// a revoked token should never be presented again, if it is then the
+	// token has leaked and the whole family has to go
+	if stored.RevokedAt.Valid {
+		cfg.handleRefreshTokenReuse(w, r, stored)
+		return
+	}
+
+	// rotate: revoke the presented token and issue its replacement in the same family
+	newRefreshToken := auth.MakeRefreshToken()
+	err = cfg.rotateRefreshToken(r, stored, newRefreshToken)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the token expired, or a concurrent request rotated it first
+		current, err := cfg.dbQueries.GetRefreshToken(r.Context(), refreshToken)
+		if err == nil && current.RevokedAt.Valid {
+			cfg.handleRefreshTokenReuse(w, r, stored)
+			return
+		}
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token", err)
+		return
+	}
+
 	// generate new JWT
-	accessToken, err := auth.MakeJWT(user.ID, cfg.secretKey, time.Hour)
+	accessToken, err := auth.MakeJWT(stored.UserID, cfg.jwtKeys, accessTokenDuration)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create new JWT", err)
 		return
 	}
+	cfg.recordOwnerEvent(r, stored.UserID, eventTokenRefreshed, "session "+stored.FamilyID.String())
+
+	// browser sessions get their new tokens as cookies, the CSRF token stays the same
+	if fromCookie {
+		cfg.setSessionCookies(w, accessToken, newRefreshToken)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 
 	// create and send JSON response
 	respondWithJSON(w, http.StatusOK, respToken{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
 	})
 }
 
+// rotateRefreshToken revokes the old token and stores the new one in a single
+// transaction. sql.ErrNoRows is returned if the old token was no longer live.
+func (cfg *apiConfig) rotateRefreshToken(r *http.Request, old database.RefreshToken, newToken string) error {
+	ctx := r.Context()
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	_, err = qtx.RotateRefreshToken(ctx, database.RotateRefreshTokenParams{
+		Token:      old.Token,
+		ReplacedBy: sql.NullString{String: newToken, Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = qtx.StoreRefreshToken(ctx, database.StoreRefreshTokenParams{
+		Token:     newToken,
+		UserID:    old.UserID,
+		ExpiresAt: time.Now().Add(refreshTokenDuration),
+		FamilyID:  old.FamilyID,
+		UserAgent: userAgent(r),
+		IpAddress: clientIP(r),
+		ClientID:  old.ClientID,
+		Scopes:    old.Scopes,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (cfg *apiConfig) handleRefreshTokenReuse(w http.ResponseWriter, r *http.Request, stored database.RefreshToken) {
+	err := cfg.dbQueries.RevokeRefreshTokenFamily(r.Context(), stored.FamilyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke refresh token family", err)
+		return
+	}
+	cfg.recordSecurityEvent(r, stored.UserID, eventRefreshTokenReused, "session "+stored.FamilyID.String()+" revoked")
+	respondWithError(w, http.StatusUnauthorized, "Refresh token has already been used", nil)
+}
+
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
-	refreshToken, err := auth.GetBearerToken(r.Header)
+	refreshToken, fromCookie, err := refreshTokenFromRequest(r)
+	if errors.Is(err, errInvalidCSRF) {
+		respondWithError(w, http.StatusForbidden, "Missing or invalid CSRF token", nil)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Couldn't find token", err)
 		return
 	}
 
-	_, err = cfg.dbQueries.RevokeRefreshToken(r.Context(), refreshToken)
+	revoked, err := cfg.dbQueries.RevokeRefreshToken(r.Context(), refreshToken)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke token", err)
 		return
 	}
+	cfg.recordOwnerEvent(r, revoked.UserID, eventTokenRevoked, "session "+revoked.FamilyID.String())
+	if fromCookie {
+		cfg.clearSessionCookies(w)
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
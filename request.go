@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// longer user agents are cut off before they are stored with a session
+const maxUserAgentLength = 256
+
+// clientIP returns the address of the client that sent the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// userAgent returns the request's User-Agent header, trimmed to a sane length.
+// Postgres rejects invalid UTF-8, so bad bytes are dropped and the cut never
+// lands in the middle of a rune.
+func userAgent(r *http.Request) string {
+	ua := strings.ToValidUTF8(r.UserAgent(), "")
+	if len(ua) > maxUserAgentLength {
+		cut := maxUserAgentLength
+		for cut > 0 && !utf8.RuneStart(ua[cut]) {
+			cut--
+		}
+		ua = ua[:cut]
+	}
+	return ua
+}
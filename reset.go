@@ -1,13 +1,29 @@
 package main
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
 
 func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 	if cfg.platform != "dev" {
-		http.Error(w, "Reset endpoint is only available in dev environment", http.StatusForbidden)
 		respondWithError(w, http.StatusForbidden, "Reset endpoint is only available in dev environment", nil)
+		return
+	}
+	// log first so the attempt is on record even if the reset fails
+	cfg.recordSecurityEvent(r, uuid.Nil, eventAdminReset, "")
+	// chirps outlive their authors as tombstones, clear them first
+	err := cfg.dbQueries.ResetChirps(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset chirps", err)
+		return
+	}
+	err = cfg.dbQueries.ResetUsers(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset users", err)
+		return
 	}
-	cfg.dbQueries.ResetUsers(r.Context())
 	cfg.fileserverHits.Store(0)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Hits reset to 0, Users table cleared"))
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// a session is a refresh token family: one login on one device
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+}
+
+func (cfg *apiConfig) handlerListSessions(w http.ResponseWriter, r *http.Request) {
+	userID := principalFromContext(r.Context()).UserID
+
+	sessions, err := cfg.dbQueries.ListActiveSessions(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve sessions", err)
+		return
+	}
+
+	resp := make([]Session, len(sessions))
+	for i := range sessions {
+		resp[i] = Session{
+			ID:         sessions[i].FamilyID,
+			CreatedAt:  sessions[i].CreatedAt,
+			LastUsedAt: sessions[i].LastUsedAt,
+			ExpiresAt:  sessions[i].ExpiresAt,
+			UserAgent:  sessions[i].UserAgent,
+			IP:         sessions[i].IpAddress,
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerRevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := principalFromContext(r.Context()).UserID
+
+	// extract sessionID from URL
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID", err)
+		return
+	}
+
+	// only sessions owned by the caller can be revoked
+	revoked, err := cfg.dbQueries.RevokeSessionForUser(r.Context(), database.RevokeSessionForUserParams{
+		FamilyID: sessionID,
+		UserID:   userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Session not found", nil)
+		return
+	}
+	cfg.recordSecurityEvent(r, userID, eventSessionRevoked, "session "+sessionID.String())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	// the refresh token identifies both the user and the session to keep
+	refreshToken, _, err := refreshTokenFromRequest(r)
+	if errors.Is(err, errInvalidCSRF) {
+		respondWithError(w, http.StatusForbidden, "Missing or invalid CSRF token", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid authorization header", err)
+		return
+	}
+
+	stored, err := cfg.dbQueries.GetLiveRefreshToken(r.Context(), refreshToken)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", err)
+		return
+	}
+
+	err = cfg.dbQueries.RevokeOtherSessions(r.Context(), database.RevokeOtherSessionsParams{
+		UserID:   stored.UserID,
+		FamilyID: stored.FamilyID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	cfg.recordSecurityEvent(r, stored.UserID, eventSessionRevoked, "all sessions except "+stored.FamilyID.String())
+
+	w.WriteHeader(http.StatusNoContent)
+}
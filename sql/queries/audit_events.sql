@@ -0,0 +1,24 @@
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (user_id, actor_id, actor, event_type, detail, ip_address, user_agent)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+);
+
+-- name: ListAuditEvents :many
+SELECT * FROM audit_events
+WHERE (sqlc.narg(user_id)::uuid IS NULL OR user_id = sqlc.narg(user_id))
+AND (sqlc.narg(event_type)::text IS NULL OR event_type = sqlc.narg(event_type))
+AND (sqlc.narg(ip_address)::text IS NULL OR ip_address = sqlc.narg(ip_address))
+AND (sqlc.narg(since)::timestamp IS NULL OR created_at >= sqlc.narg(since))
+AND (sqlc.narg(until)::timestamp IS NULL OR created_at < sqlc.narg(until))
+AND (sqlc.narg(before_id)::uuid IS NULL OR (created_at, id) < (
+    SELECT b.created_at, b.id FROM audit_events b WHERE b.id = sqlc.narg(before_id)
+))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(max_rows);
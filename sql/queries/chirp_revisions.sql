@@ -0,0 +1,15 @@
+-- name: CreateChirpRevision :exec
+INSERT INTO chirp_revisions (chirp_id, body)
+VALUES (
+    $1,
+    $2
+);
+
+-- name: DeleteChirpRevisions :exec
+DELETE FROM chirp_revisions
+WHERE chirp_id = $1;
+
+-- name: ListChirpRevisions :many
+SELECT * FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY replaced_at ASC, id ASC;
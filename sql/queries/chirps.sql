@@ -1,26 +1,184 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to, kind, original_id)
 VALUES (
     DEFAULT,
     DEFAULT,
     DEFAULT,
     $1,
-    $2
+    $2,
+    $3,
+    $4,
+    $5
 )
 RETURNING *;
 
--- name: GetAllChirps :many
-SELECT * FROM chirps
-ORDER BY created_at ASC;
-
 -- name: GetAllChirpsByUser :many
-SELECT * FROM chirps
-WHERE user_id = $1;
+SELECT c.* FROM chirps c
+JOIN users u ON u.id = c.user_id
+WHERE c.user_id = $1
+AND c.deleted_at IS NULL
+AND u.deleted_at IS NULL;
+
+-- name: ListChirpIDsByUser :many
+SELECT id FROM chirps
+WHERE user_id = $1
+AND deleted_at IS NULL
+ORDER BY created_at ASC;
 
 -- name: GetChirpByID :one
+SELECT c.* FROM chirps c
+JOIN users u ON u.id = c.user_id
+WHERE c.id = $1
+AND u.deleted_at IS NULL;
+
+-- name: GetChirpForUpdate :one
 SELECT * FROM chirps
-WHERE id = $1;
+WHERE id = $1
+FOR UPDATE;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps SET body = sqlc.arg(body),
+edited_at = NOW(),
+updated_at = NOW()
+WHERE id = sqlc.arg(id)
+AND deleted_at IS NULL
+AND created_at > NOW() - make_interval(secs => sqlc.arg(edit_window_seconds)::float8)
+RETURNING *;
 
 -- name: DeleteChirp :exec
 DELETE FROM chirps
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: DeleteRechirp :one
+DELETE FROM chirps
+WHERE user_id = $1
+AND original_id = $2
+AND kind = 'rechirp'
+RETURNING *;
+
+-- name: ResetChirps :exec
+DELETE FROM chirps;
+
+-- name: DeleteRechirpsOf :exec
+DELETE FROM chirps
+WHERE original_id = $1
+AND kind = 'rechirp';
+
+-- name: TombstoneChirp :one
+UPDATE chirps SET body = '',
+rechirp_count = 0,
+reaction_counts = '{}',
+deleted_at = NOW(),
+updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: IncrementReplyCount :execrows
+UPDATE chirps SET reply_count = reply_count + 1
+WHERE id = $1
+AND deleted_at IS NULL
+AND kind <> 'rechirp'
+AND EXISTS (SELECT 1 FROM users u WHERE u.id = chirps.user_id AND u.deleted_at IS NULL);
+
+-- name: IncrementQuoteCount :execrows
+UPDATE chirps SET quote_count = quote_count + 1
+WHERE id = $1
+AND deleted_at IS NULL
+AND kind <> 'rechirp'
+AND EXISTS (SELECT 1 FROM users u WHERE u.id = chirps.user_id AND u.deleted_at IS NULL);
+
+-- name: IncrementRechirpCount :execrows
+UPDATE chirps SET rechirp_count = rechirp_count + 1
+WHERE id = $1
+AND deleted_at IS NULL
+AND kind <> 'rechirp'
+AND EXISTS (SELECT 1 FROM users u WHERE u.id = chirps.user_id AND u.deleted_at IS NULL);
+
+-- name: DecrementQuoteCount :one
+UPDATE chirps SET quote_count = quote_count - 1
+WHERE id = $1
+AND quote_count > 0
+RETURNING *;
+
+-- name: DecrementRechirpCount :exec
+UPDATE chirps SET rechirp_count = rechirp_count - 1
+WHERE id = $1
+AND rechirp_count > 0;
+
+-- name: DecrementReplyCount :one
+UPDATE chirps SET reply_count = reply_count - 1
+WHERE id = $1
+AND reply_count > 0
+RETURNING *;
+
+-- name: ListChirpsAsc :many
+SELECT c.* FROM chirps c
+JOIN users u ON u.id = c.user_id
+WHERE u.deleted_at IS NULL
+AND c.deleted_at IS NULL
+AND (sqlc.narg(author_id)::uuid IS NULL OR c.user_id = sqlc.narg(author_id))
+AND (sqlc.narg(since)::timestamp IS NULL OR c.created_at >= sqlc.narg(since))
+AND (sqlc.narg(until)::timestamp IS NULL OR c.created_at < sqlc.narg(until))
+AND (sqlc.narg(cursor_created_at)::timestamp IS NULL OR (c.created_at, c.id) > (sqlc.narg(cursor_created_at), sqlc.narg(cursor_id)::uuid))
+ORDER BY c.created_at ASC, c.id ASC
+LIMIT sqlc.narg(max_rows);
+
+-- name: ListChirpsDesc :many
+SELECT c.* FROM chirps c
+JOIN users u ON u.id = c.user_id
+WHERE u.deleted_at IS NULL
+AND c.deleted_at IS NULL
+AND (sqlc.narg(author_id)::uuid IS NULL OR c.user_id = sqlc.narg(author_id))
+AND (sqlc.narg(since)::timestamp IS NULL OR c.created_at >= sqlc.narg(since))
+AND (sqlc.narg(until)::timestamp IS NULL OR c.created_at < sqlc.narg(until))
+AND (sqlc.narg(cursor_created_at)::timestamp IS NULL OR (c.created_at, c.id) < (sqlc.narg(cursor_created_at), sqlc.narg(cursor_id)::uuid))
+ORDER BY c.created_at DESC, c.id DESC
+LIMIT sqlc.narg(max_rows);
+
+-- name: ListChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT c.*, 1 AS depth
+    FROM chirps c
+    WHERE c.id = (SELECT r.in_reply_to FROM chirps r WHERE r.id = sqlc.arg(chirp_id))
+    UNION ALL
+    SELECT c.*, a.depth + 1
+    FROM chirps c
+    JOIN ancestors a ON c.id = a.in_reply_to
+    WHERE a.depth < sqlc.arg(max_depth)
+)
+SELECT a.id, a.created_at, a.updated_at, a.body, a.user_id, a.edited_at, a.in_reply_to, a.reply_count,
+    COALESCE(a.deleted_at, u.deleted_at) AS deleted_at, a.kind, a.original_id, a.rechirp_count, a.quote_count, a.reaction_counts
+FROM ancestors a
+LEFT JOIN users u ON u.id = a.user_id
+ORDER BY a.depth DESC;
+
+-- name: ListChirpDescendants :many
+WITH RECURSIVE descendants AS (
+    SELECT c.*, 1 AS depth, ARRAY[to_char(c.created_at, 'YYYYMMDDHH24MISSUS') || c.id::text] AS path
+    FROM chirps c
+    WHERE c.in_reply_to = sqlc.arg(chirp_id)
+    UNION ALL
+    SELECT c.*, d.depth + 1, d.path || (to_char(c.created_at, 'YYYYMMDDHH24MISSUS') || c.id::text)
+    FROM chirps c
+    JOIN descendants d ON c.in_reply_to = d.id
+    WHERE d.depth < sqlc.arg(max_depth)
+)
+SELECT d.id, d.created_at, d.updated_at, d.body, d.user_id, d.edited_at, d.in_reply_to, d.reply_count,
+    COALESCE(d.deleted_at, u.deleted_at) AS deleted_at, d.kind, d.original_id, d.rechirp_count, d.quote_count, d.reaction_counts,
+    d.depth::integer AS depth,
+    d.path::text[] AS path
+FROM descendants d
+LEFT JOIN users u ON u.id = d.user_id
+WHERE (sqlc.narg(after_path)::text[] IS NULL OR d.path > sqlc.narg(after_path)::text[])
+ORDER BY d.path
+LIMIT sqlc.arg(max_rows);
+
+-- name: ListRechirps :many
+SELECT c.id, c.user_id, c.created_at FROM chirps c
+JOIN users u ON u.id = c.user_id
+WHERE c.original_id = sqlc.arg(original_id)
+AND c.kind = 'rechirp'
+AND u.deleted_at IS NULL
+AND (sqlc.narg(cursor_created_at)::timestamp IS NULL OR (c.created_at, c.id) < (sqlc.narg(cursor_created_at), sqlc.narg(cursor_id)::uuid))
+ORDER BY c.created_at DESC, c.id DESC
+LIMIT sqlc.arg(max_rows);
@@ -0,0 +1,55 @@
+-- name: CreateInvite :one
+INSERT INTO invites (created_by, code_hash, max_uses, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: RedeemInvite :one
+UPDATE invites SET uses = uses + 1
+WHERE code_hash = $1
+AND uses < max_uses
+AND revoked_at IS NULL
+AND (expires_at IS NULL OR expires_at > NOW())
+RETURNING *;
+
+-- name: CreateInviteRedemption :exec
+INSERT INTO invite_redemptions (user_id, invite_id)
+VALUES (
+    $1,
+    $2
+);
+
+-- name: ListInvites :many
+SELECT * FROM invites
+ORDER BY created_at DESC;
+
+-- name: ListInvitesByCreator :many
+SELECT * FROM invites
+WHERE created_by = $1
+ORDER BY created_at DESC;
+
+-- name: RevokeInvite :execrows
+UPDATE invites SET revoked_at = NOW()
+WHERE id = sqlc.arg(id)
+AND (sqlc.narg(created_by)::uuid IS NULL OR created_by = sqlc.narg(created_by))
+AND revoked_at IS NULL;
+
+-- name: RevokeInvitesByCreator :exec
+UPDATE invites SET revoked_at = NOW()
+WHERE created_by = $1
+AND revoked_at IS NULL;
+
+-- name: MarkInvitesCreatorDeleted :exec
+UPDATE invites SET created_by_deleted = TRUE
+WHERE created_by = $1;
+
+-- name: ListInviteRedemptions :many
+SELECT r.user_id, u.email, r.redeemed_at
+FROM invite_redemptions r
+JOIN users u ON u.id = r.user_id
+WHERE r.invite_id = $1
+ORDER BY r.redeemed_at ASC;
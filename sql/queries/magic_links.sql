@@ -0,0 +1,14 @@
+-- name: CreateMagicLink :exec
+INSERT INTO magic_links (token_hash, user_id, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3
+);
+
+-- name: ConsumeMagicLink :one
+UPDATE magic_links SET used_at = NOW()
+WHERE token_hash = $1
+AND used_at IS NULL
+AND expires_at > NOW()
+RETURNING *;
@@ -0,0 +1,19 @@
+-- name: CreateMFAChallenge :exec
+INSERT INTO mfa_challenges (token_hash, user_id, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3
+);
+
+-- name: ReserveMFAChallengeAttempt :execrows
+UPDATE mfa_challenges SET attempts = attempts + 1
+WHERE token_hash = sqlc.arg(token_hash)
+AND used_at IS NULL
+AND expires_at > NOW()
+AND attempts < sqlc.arg(max_attempts);
+
+-- name: ConsumeMFAChallenge :execrows
+UPDATE mfa_challenges SET used_at = NOW()
+WHERE token_hash = $1
+AND used_at IS NULL;
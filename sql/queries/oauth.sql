@@ -0,0 +1,43 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (owner_id, name, secret_hash, redirect_uris, scopes)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients
+WHERE id = $1;
+
+-- name: ListOAuthClients :many
+SELECT * FROM oauth_clients
+WHERE owner_id = $1
+ORDER BY created_at DESC;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE id = $1
+AND owner_id = $2;
+
+-- name: CreateAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+);
+
+-- name: ConsumeAuthorizationCode :one
+UPDATE oauth_authorization_codes SET used_at = NOW()
+WHERE code_hash = $1
+AND used_at IS NULL
+AND expires_at > NOW()
+RETURNING *;
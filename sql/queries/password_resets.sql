@@ -0,0 +1,27 @@
+-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens (token_hash, user_id, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3
+);
+
+-- name: ConsumePasswordResetToken :one
+UPDATE password_reset_tokens SET used_at = NOW()
+WHERE token_hash = $1
+AND used_at IS NULL
+AND expires_at > NOW()
+RETURNING *;
+
+-- name: GetUserByPasswordResetToken :one
+SELECT u.*
+FROM users u
+JOIN password_reset_tokens prt ON u.id = prt.user_id
+WHERE prt.token_hash = $1
+AND prt.used_at IS NULL
+AND prt.expires_at > NOW();
+
+-- name: InvalidatePasswordResetTokens :exec
+UPDATE password_reset_tokens SET used_at = NOW()
+WHERE user_id = $1
+AND used_at IS NULL;
@@ -0,0 +1,49 @@
+-- name: CreateReaction :execrows
+INSERT INTO chirp_reactions (chirp_id, user_id, emoji)
+VALUES (
+    $1,
+    $2,
+    $3
+)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteReaction :execrows
+DELETE FROM chirp_reactions
+WHERE chirp_id = $1
+AND user_id = $2
+AND emoji = $3;
+
+-- name: DeleteChirpReactions :exec
+DELETE FROM chirp_reactions
+WHERE chirp_id = $1;
+
+-- name: IncrementReactionCount :exec
+UPDATE chirps SET reaction_counts = jsonb_set(
+    reaction_counts,
+    ARRAY[sqlc.arg(emoji)::text],
+    to_jsonb(COALESCE((reaction_counts->>sqlc.arg(emoji)::text)::integer, 0) + 1)
+)
+WHERE id = sqlc.arg(id);
+
+-- name: DecrementReactionCount :exec
+UPDATE chirps SET reaction_counts = CASE
+    WHEN (reaction_counts->>sqlc.arg(emoji)::text)::integer > 1
+    THEN jsonb_set(reaction_counts, ARRAY[sqlc.arg(emoji)::text], to_jsonb((reaction_counts->>sqlc.arg(emoji)::text)::integer - 1))
+    ELSE reaction_counts - sqlc.arg(emoji)::text
+END
+WHERE id = sqlc.arg(id);
+
+-- name: ListReactionsByChirp :many
+SELECT r.user_id, r.created_at FROM chirp_reactions r
+JOIN users u ON u.id = r.user_id
+WHERE r.chirp_id = sqlc.arg(chirp_id)
+AND r.emoji = sqlc.arg(emoji)
+AND u.deleted_at IS NULL
+AND (sqlc.narg(cursor_created_at)::timestamp IS NULL OR (r.created_at, r.user_id) < (sqlc.narg(cursor_created_at), sqlc.narg(cursor_user_id)::uuid))
+ORDER BY r.created_at DESC, r.user_id DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: ListReactionsByUser :many
+SELECT * FROM chirp_reactions
+WHERE user_id = $1
+ORDER BY created_at ASC;
@@ -1,14 +1,29 @@
 -- name: StoreRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at)
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, family_id, user_agent, ip_address, client_id, scopes)
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
-    $3
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8
 )
 RETURNING *;
 
+-- name: GetRefreshToken :one
+SELECT * FROM refresh_tokens
+WHERE token = $1;
+
+-- name: GetLiveRefreshToken :one
+SELECT * FROM refresh_tokens
+WHERE token = $1
+AND revoked_at IS NULL
+AND expires_at > NOW();
+
 -- name: GetUserByRefreshToken :one
 SELECT u.*
 FROM users u
@@ -21,4 +36,52 @@ AND expires_at > NOW();
 UPDATE refresh_tokens SET revoked_at = NOW(),
 updated_at = NOW()
 WHERE token = $1
-RETURNING *;
\ No newline at end of file
+RETURNING *;
+
+-- name: RotateRefreshToken :one
+UPDATE refresh_tokens SET revoked_at = NOW(),
+updated_at = NOW(),
+replaced_by = $2
+WHERE token = $1
+AND revoked_at IS NULL
+AND expires_at > NOW()
+RETURNING *;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens SET revoked_at = NOW(),
+updated_at = NOW()
+WHERE family_id = $1
+AND revoked_at IS NULL;
+
+-- name: ListActiveSessions :many
+SELECT rt.family_id,
+    (SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = rt.family_id)::TIMESTAMP AS created_at,
+    rt.created_at AS last_used_at,
+    rt.expires_at,
+    rt.user_agent,
+    rt.ip_address
+FROM refresh_tokens rt
+WHERE rt.user_id = $1
+AND rt.revoked_at IS NULL
+AND rt.expires_at > NOW()
+ORDER BY rt.created_at DESC;
+
+-- name: RevokeSessionForUser :execrows
+UPDATE refresh_tokens SET revoked_at = NOW(),
+updated_at = NOW()
+WHERE family_id = $1
+AND user_id = $2
+AND revoked_at IS NULL;
+
+-- name: RevokeOtherSessions :exec
+UPDATE refresh_tokens SET revoked_at = NOW(),
+updated_at = NOW()
+WHERE user_id = $1
+AND family_id <> $2
+AND revoked_at IS NULL;
+
+-- name: RevokeAllRefreshTokensForUser :exec
+UPDATE refresh_tokens SET revoked_at = NOW(),
+updated_at = NOW()
+WHERE user_id = $1
+AND revoked_at IS NULL;
@@ -0,0 +1,16 @@
+-- name: RevokeAccessToken :exec
+INSERT INTO revoked_access_tokens (jti, user_id, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3
+)
+ON CONFLICT (jti) DO NOTHING;
+
+-- name: ListRevokedAccessTokens :many
+SELECT jti, expires_at FROM revoked_access_tokens
+WHERE expires_at > NOW();
+
+-- name: DeleteExpiredRevokedAccessTokens :execrows
+DELETE FROM revoked_access_tokens
+WHERE expires_at <= NOW();
@@ -13,17 +13,103 @@ RETURNING *;
 DELETE FROM users;
 
 -- name: GetUserByEmail :one
-SELECT * FROM users WHERE email = $1;
+SELECT * FROM users WHERE lower(email) = lower($1) AND deleted_at IS NULL;
 
--- name: UpdateUserCredentials :one
-UPDATE users SET email = $1,
-hashed_password = $2,
+-- name: SetPendingEmail :one
+UPDATE users SET pending_email = $2,
 updated_at = NOW()
-WHERE id = $3
+WHERE id = $1
+RETURNING *;
+
+-- name: ConfirmPendingEmail :one
+UPDATE users SET email = pending_email,
+pending_email = NULL,
+email_verified = TRUE,
+updated_at = NOW()
+WHERE id = $1
+AND pending_email = $2
 RETURNING *;
 
 -- name: UpgradeUserToChirpyRed :one
 UPDATE users SET is_chirpy_red = TRUE,
 updated_at = NOW()
 WHERE id = $1
-RETURNING *;
\ No newline at end of file
+RETURNING *;
+
+-- name: GetUserByID :one
+SELECT * FROM users WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: SetTOTPSecret :exec
+UPDATE users SET totp_secret = $2,
+totp_enabled = FALSE,
+updated_at = NOW()
+WHERE id = $1;
+
+-- name: EnableTOTP :exec
+UPDATE users SET totp_enabled = TRUE,
+totp_last_step = $2,
+updated_at = NOW()
+WHERE id = $1;
+
+-- name: DisableTOTP :exec
+UPDATE users SET totp_secret = NULL,
+totp_enabled = FALSE,
+totp_last_step = 0,
+updated_at = NOW()
+WHERE id = $1;
+
+-- name: UseTOTPStep :execrows
+UPDATE users SET totp_last_step = $2
+WHERE id = $1
+AND totp_last_step < $2;
+
+
+-- name: MarkEmailVerified :one
+UPDATE users SET email_verified = TRUE,
+updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: UpdateUserPassword :exec
+UPDATE users SET hashed_password = $2,
+updated_at = NOW()
+WHERE id = $1;
+
+-- name: SetUserRole :one
+UPDATE users SET role = $2,
+updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: RehashUserPassword :exec
+UPDATE users SET hashed_password = sqlc.arg(new_hash)
+WHERE id = sqlc.arg(id) AND hashed_password = sqlc.arg(old_hash);
+
+-- name: SoftDeleteUser :one
+UPDATE users SET deleted_at = NOW(),
+updated_at = NOW()
+WHERE id = $1
+AND deleted_at IS NULL
+RETURNING *;
+
+-- name: GetDeletedUserByID :one
+SELECT * FROM users
+WHERE id = $1
+AND deleted_at IS NOT NULL;
+
+-- name: RestoreUser :one
+UPDATE users SET deleted_at = NULL,
+updated_at = NOW()
+WHERE id = $1
+AND deleted_at = $2
+RETURNING *;
+
+-- name: ListUsersToPurge :many
+SELECT id FROM users
+WHERE deleted_at < $1
+ORDER BY deleted_at ASC;
+
+-- name: PurgeUser :execrows
+DELETE FROM users
+WHERE id = $1
+AND deleted_at IS NOT NULL;
@@ -0,0 +1,12 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+ADD COLUMN ip_address TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_user_id_idx;
+ALTER TABLE refresh_tokens
+DROP COLUMN ip_address,
+DROP COLUMN user_agent;
@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- accounts created before verification existed keep working
+UPDATE users SET email_verified = TRUE;
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN email_verified;
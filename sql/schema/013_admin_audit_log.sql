@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE admin_audit_log (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    actor_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX admin_audit_log_created_at_idx ON admin_audit_log (created_at);
+
+-- +goose Down
+DROP TABLE admin_audit_log;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE revoked_access_tokens (
+    jti TEXT PRIMARY KEY,
+    seq BIGSERIAL NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX revoked_access_tokens_expires_at_idx ON revoked_access_tokens (expires_at);
+
+-- +goose Down
+DROP TABLE revoked_access_tokens;
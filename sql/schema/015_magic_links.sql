@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE magic_links (
+    token_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX magic_links_user_id_idx ON magic_links (user_id);
+
+-- +goose Down
+DROP TABLE magic_links;
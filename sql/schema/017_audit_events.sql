@@ -0,0 +1,33 @@
+-- +goose Up
+CREATE TABLE audit_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    -- no foreign keys, events have to outlive the accounts they are about
+    user_id UUID,
+    actor_id UUID,
+    actor TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    detail TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX audit_events_user_id_idx ON audit_events (user_id, created_at DESC, id DESC);
+CREATE INDEX audit_events_event_type_idx ON audit_events (event_type, created_at DESC);
+CREATE INDEX audit_events_created_at_idx ON audit_events (created_at DESC, id DESC);
+
+-- +goose StatementBegin
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER audit_events_append_only
+BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+-- +goose Down
+DROP TABLE audit_events;
+DROP FUNCTION audit_events_append_only();
@@ -0,0 +1,26 @@
+-- +goose Up
+CREATE TABLE invites (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    -- NULL for invites made with the admin key
+    created_by UUID REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL UNIQUE,
+    max_uses INTEGER NOT NULL CHECK (max_uses > 0),
+    uses INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX invites_created_by_idx ON invites (created_by);
+
+CREATE TABLE invite_redemptions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    invite_id UUID NOT NULL REFERENCES invites(id) ON DELETE CASCADE,
+    redeemed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX invite_redemptions_invite_id_idx ON invite_redemptions (invite_id);
+
+-- +goose Down
+DROP TABLE invite_redemptions;
+DROP TABLE invites;
@@ -0,0 +1,19 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN edited_at TIMESTAMP;
+
+-- every edit keeps the body it replaced
+CREATE TABLE chirp_revisions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    replaced_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX chirp_revisions_chirp_id_idx ON chirp_revisions (chirp_id, replaced_at);
+
+-- +goose Down
+DROP TABLE chirp_revisions;
+
+ALTER TABLE chirps
+DROP COLUMN edited_at;
@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN in_reply_to UUID REFERENCES chirps(id) ON DELETE SET NULL,
+ADD COLUMN reply_count INTEGER NOT NULL DEFAULT 0,
+-- deleted chirps with replies stay behind as tombstones so their threads hold together
+ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX chirps_in_reply_to_idx ON chirps (in_reply_to, created_at, id);
+
+-- +goose Down
+ALTER TABLE chirps
+DROP COLUMN deleted_at,
+DROP COLUMN reply_count,
+DROP COLUMN in_reply_to;
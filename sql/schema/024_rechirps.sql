@@ -0,0 +1,22 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN kind TEXT NOT NULL DEFAULT 'chirp' CHECK (kind IN ('chirp', 'rechirp', 'quote')),
+-- rechirps and quotes point at the chirp they share
+ADD COLUMN original_id UUID REFERENCES chirps(id) ON DELETE SET NULL,
+ADD COLUMN rechirp_count INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN quote_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX chirps_original_id_idx ON chirps (original_id, created_at, id);
+
+-- a user can only rechirp a chirp once, quotes are not limited
+CREATE UNIQUE INDEX chirps_rechirp_once_idx ON chirps (user_id, original_id) WHERE kind = 'rechirp';
+
+-- +goose Down
+DROP INDEX chirps_rechirp_once_idx;
+DROP INDEX chirps_original_id_idx;
+
+ALTER TABLE chirps
+DROP COLUMN quote_count,
+DROP COLUMN rechirp_count,
+DROP COLUMN original_id,
+DROP COLUMN kind;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- one reaction of each kind per user and chirp
+CREATE TABLE chirp_reactions (
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    emoji TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (chirp_id, emoji, user_id)
+);
+
+CREATE INDEX chirp_reactions_list_idx ON chirp_reactions (chirp_id, emoji, created_at, user_id);
+CREATE INDEX chirp_reactions_user_id_idx ON chirp_reactions (user_id, created_at);
+
+-- counts per emoji, kept up to date with chirp_reactions so reading a chirp
+-- doesn't have to count its reactions
+ALTER TABLE chirps
+ADD COLUMN reaction_counts JSONB NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE chirps
+DROP COLUMN reaction_counts;
+
+DROP TABLE chirp_reactions;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- the denylist now reloads every unexpired row, nothing reads the sequence anymore
+ALTER TABLE revoked_access_tokens
+DROP COLUMN seq;
+
+-- +goose Down
+ALTER TABLE revoked_access_tokens
+ADD COLUMN seq BIGSERIAL NOT NULL UNIQUE;
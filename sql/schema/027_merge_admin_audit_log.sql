@@ -0,0 +1,35 @@
+-- +goose Up
+-- admin actions now go to audit_events, carry the old entries over
+INSERT INTO audit_events (id, created_at, user_id, actor_id, actor, event_type, detail, ip_address)
+SELECT
+    id,
+    created_at,
+    CASE WHEN action LIKE 'set_role:%' THEN target::uuid END,
+    actor_user_id,
+    actor,
+    CASE
+        WHEN action = 'reset' THEN 'admin_reset'
+        WHEN action = 'unlock_account' THEN 'admin_account_unlocked'
+        WHEN action = 'unlock_ip' THEN 'admin_ip_unlocked'
+        WHEN action LIKE 'set_role:%' THEN 'admin_role_changed'
+        ELSE 'admin_' || action
+    END,
+    CASE WHEN action LIKE 'set_role:%' THEN 'role: ' || substring(action FROM 10) ELSE target END,
+    ip_address
+FROM admin_audit_log;
+
+DROP TABLE admin_audit_log;
+
+-- +goose Down
+-- audit_events is append-only, the copied entries stay there
+CREATE TABLE admin_audit_log (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    actor_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX admin_audit_log_created_at_idx ON admin_audit_log (created_at);
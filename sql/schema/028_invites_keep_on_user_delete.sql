@@ -0,0 +1,10 @@
+-- +goose Up
+-- invites and their redemption history outlive the user who made them
+ALTER TABLE invites
+DROP CONSTRAINT invites_created_by_fkey,
+ADD CONSTRAINT invites_created_by_fkey FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL;
+
+-- +goose Down
+ALTER TABLE invites
+DROP CONSTRAINT invites_created_by_fkey,
+ADD CONSTRAINT invites_created_by_fkey FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE;
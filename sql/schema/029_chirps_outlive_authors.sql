@@ -0,0 +1,22 @@
+-- +goose Up
+-- purging an account deletes its chirps first, only tombstones that still
+-- hold replies or quotes together are left, and they lose their author
+ALTER TABLE chirps
+ALTER COLUMN user_id DROP NOT NULL,
+DROP CONSTRAINT chirps_user_id_fkey,
+ADD CONSTRAINT chirps_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+
+-- earlier purges deleted replies without counting them down
+UPDATE chirps SET reply_count = (
+    SELECT count(*) FROM chirps r
+    WHERE r.in_reply_to = chirps.id
+);
+
+-- +goose Down
+DELETE FROM chirps
+WHERE user_id IS NULL;
+
+ALTER TABLE chirps
+DROP CONSTRAINT chirps_user_id_fkey,
+ADD CONSTRAINT chirps_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+ALTER COLUMN user_id SET NOT NULL;
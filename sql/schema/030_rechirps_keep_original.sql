@@ -0,0 +1,29 @@
+-- +goose Up
+-- rechirps left behind by earlier purges have nothing to show
+DELETE FROM chirps
+WHERE kind = 'rechirp'
+AND original_id IS NULL;
+
+-- and the counts they were part of were never brought down
+UPDATE chirps SET rechirp_count = (
+    SELECT count(*) FROM chirps r
+    WHERE r.original_id = chirps.id
+    AND r.kind = 'rechirp'
+), quote_count = (
+    SELECT count(*) FROM chirps q
+    WHERE q.original_id = chirps.id
+    AND q.kind = 'quote'
+);
+
+-- deleteChirp removes rechirps and keeps quoted chirps as tombstones, deleting
+-- an original any other way is a bug and must fail instead of orphaning them
+ALTER TABLE chirps
+DROP CONSTRAINT chirps_original_id_fkey,
+ADD CONSTRAINT chirps_original_id_fkey FOREIGN KEY (original_id) REFERENCES chirps(id),
+ADD CONSTRAINT chirps_rechirp_original_check CHECK (kind <> 'rechirp' OR original_id IS NOT NULL);
+
+-- +goose Down
+ALTER TABLE chirps
+DROP CONSTRAINT chirps_rechirp_original_check,
+DROP CONSTRAINT chirps_original_id_fkey,
+ADD CONSTRAINT chirps_original_id_fkey FOREIGN KEY (original_id) REFERENCES chirps(id) ON DELETE SET NULL;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- deleted accounts used to keep their reactions, and purges dropped them
+-- without counting them down
+DELETE FROM chirp_reactions
+WHERE user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL);
+
+UPDATE chirps SET reaction_counts = COALESCE((
+    SELECT jsonb_object_agg(emoji, n)
+    FROM (
+        SELECT r.emoji, count(*) AS n FROM chirp_reactions r
+        WHERE r.chirp_id = chirps.id
+        GROUP BY r.emoji
+    ) counts
+), '{}');
+
+-- +goose Down
+-- nothing to undo, the counts were wrong before
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE mfa_challenges (
+    token_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX mfa_challenges_user_id_idx ON mfa_challenges (user_id);
+
+-- +goose Down
+DROP TABLE mfa_challenges;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- addresses are stored lowercased from now on, older ones may still have
+-- capitals, so lookups and uniqueness ignore case
+CREATE UNIQUE INDEX users_email_lower_idx ON users (lower(email));
+
+-- +goose Down
+DROP INDEX users_email_lower_idx;
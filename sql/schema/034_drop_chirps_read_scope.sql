@@ -0,0 +1,10 @@
+-- +goose Up
+-- chirps are public, chirps:read never gated anything
+UPDATE personal_access_tokens SET scopes = array_remove(scopes, 'chirps:read');
+UPDATE oauth_clients SET scopes = array_remove(scopes, 'chirps:read');
+UPDATE oauth_authorization_codes SET scopes = array_remove(scopes, 'chirps:read');
+UPDATE refresh_tokens SET scopes = array_remove(scopes, 'chirps:read')
+WHERE scopes IS NOT NULL;
+
+-- +goose Down
+-- nothing to put back, the scope allowed nothing
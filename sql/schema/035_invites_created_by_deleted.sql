@@ -0,0 +1,10 @@
+-- +goose Up
+-- created_by is NULL both for invites made with the admin key and for invites
+-- whose creator was purged, this tells the two apart. Invites of creators
+-- purged before this migration can't be told apart anymore and stay FALSE.
+ALTER TABLE invites
+ADD COLUMN created_by_deleted BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE invites
+DROP COLUMN created_by_deleted;
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/cryptidcodes/chirpy/internal/auth"
+	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// DO NOT DELETE: USED IN RESPONSE STRUCTURES
+// database.PersonalAccessToken DOES NOT HAVE JSON TAGS
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func personalAccessTokenFromDB(pat database.PersonalAccessToken) PersonalAccessToken {
+	resp := PersonalAccessToken{
+		ID:        pat.ID,
+		CreatedAt: pat.CreatedAt,
+		Name:      pat.Name,
+		Scopes:    pat.Scopes,
+	}
+	if pat.ExpiresAt.Valid {
+		resp.ExpiresAt = &pat.ExpiresAt.Time
+	}
+	if pat.LastUsedAt.Valid {
+		resp.LastUsedAt = &pat.LastUsedAt.Time
+	}
+	return resp
+}
+
+func (cfg *apiConfig) handlerCreatePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	type response struct {
+		PersonalAccessToken
+		Token string `json:"token"`
+	}
+
+	userID := principalFromContext(r.Context()).UserID
+
+	// decode JSON request body
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Token name is required", nil)
+		return
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required", nil)
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !auth.ValidScope(scope) {
+			respondWithError(w, http.StatusBadRequest, "Unknown scope: "+scope, nil)
+			return
+		}
+	}
+	if params.ExpiresInDays < 0 {
+		respondWithError(w, http.StatusBadRequest, "expires_in_days can't be negative", nil)
+		return
+	}
+
+	// tokens without an expiry live until they are revoked
+	expiresAt := sql.NullTime{}
+	if params.ExpiresInDays > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().Add(time.Duration(params.ExpiresInDays) * 24 * time.Hour), Valid: true}
+	}
+
+	// only the hash is stored, the token itself is only shown in this response
+	newToken := auth.MakePersonalAccessToken()
+	pat, err := cfg.dbQueries.CreatePersonalAccessToken(r.Context(), database.CreatePersonalAccessTokenParams{
+		UserID:    userID,
+		Name:      params.Name,
+		TokenHash: auth.HashToken(newToken),
+		Scopes:    params.Scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token", err)
+		return
+	}
+	cfg.recordSecurityEvent(r, userID, eventAccessTokenCreated, pat.Name)
+
+	respondWithJSON(w, http.StatusCreated, response{
+		PersonalAccessToken: personalAccessTokenFromDB(pat),
+		Token:               newToken,
+	})
+}
+
+func (cfg *apiConfig) handlerListPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	userID := principalFromContext(r.Context()).UserID
+
+	pats, err := cfg.dbQueries.ListPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve tokens", err)
+		return
+	}
+
+	resp := make([]PersonalAccessToken, len(pats))
+	for i := range pats {
+		resp[i] = personalAccessTokenFromDB(pats[i])
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerRevokePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID := principalFromContext(r.Context()).UserID
+
+	// extract tokenID from URL
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid token ID", err)
+		return
+	}
+
+	revoked, err := cfg.dbQueries.RevokePersonalAccessToken(r.Context(), database.RevokePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke token", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Token not found", nil)
+		return
+	}
+	cfg.recordSecurityEvent(r, userID, eventAccessTokenRevoked, tokenID.String())
+
+	w.WriteHeader(http.StatusNoContent)
+}
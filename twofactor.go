@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cryptidcodes/chirpy/internal/auth"
+	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer        = "Chirpy"
+	recoveryCodeCount = 10
+)
+
+func (cfg *apiConfig) handlerEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+
+	userID := principalFromContext(r.Context()).UserID
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	if user.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	// the secret stays pending until the user proves their app can generate codes for it
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate secret", err)
+		return
+	}
+	err = cfg.dbQueries.SetTOTPSecret(r.Context(), database.SetTOTPSecretParams{
+		ID:         user.ID,
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(secret, user.Email, totpIssuer),
+	})
+}
+
+func (cfg *apiConfig) handlerConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	userID := principalFromContext(r.Context()).UserID
+
+	// decode JSON request body
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	if user.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+	if !user.TotpSecret.Valid {
+		respondWithError(w, http.StatusBadRequest, "Two-factor enrollment has not been started", nil)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(user.TotpSecret.String, params.Code, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate recovery codes", err)
+		return
+	}
+
+	// enable 2FA and replace any old recovery codes together
+	err = cfg.enableTOTP(r.Context(), user.ID, step, codes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+	cfg.recordSecurityEvent(r, user.ID, eventTwoFactorEnabled, "")
+
+	// recovery codes are only stored hashed, this is the only time they are shown
+	respondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes: codes,
+	})
+}
+
+func (cfg *apiConfig) enableTOTP(ctx context.Context, userID uuid.UUID, step int64, recoveryCodes []string) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	err = qtx.EnableTOTP(ctx, database.EnableTOTPParams{
+		ID:           userID,
+		TotpLastStep: step,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = qtx.DeleteRecoveryCodes(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, code := range recoveryCodes {
+		err = qtx.CreateRecoveryCode(ctx, database.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashToken(code),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (cfg *apiConfig) handlerDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	userID := principalFromContext(r.Context()).UserID
+
+	// decode JSON request body
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	if !user.TotpEnabled {
+		respondWithError(w, http.StatusBadRequest, "Two-factor authentication is not enabled", nil)
+		return
+	}
+
+	// a stolen access token alone must not be enough to turn 2FA off
+	ok, err := cfg.checkSecondFactor(r.Context(), user, params.Code, params.RecoveryCode)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return
+	}
+
+	err = cfg.dbQueries.DisableTOTP(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+	err = cfg.dbQueries.DeleteRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete recovery codes", err)
+		return
+	}
+	cfg.recordSecurityEvent(r, user.ID, eventTwoFactorDisabled, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerLoginTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+		UseCookies     bool   `json:"use_cookies"`
+	}
+
+	// decode JSON request body
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	// the challenge token proves the password step already succeeded
+	challenge, err := auth.ValidatePurposeJWT(params.ChallengeToken, auth.PurposeMFAChallenge, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge token", err)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), challenge.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge token", err)
+		return
+	}
+
+	// wrong codes count against the same limits as wrong passwords
+	accountKey := loginAccountKey(user.Email)
+	ipKey := loginIPKey(r)
+	if !cfg.reserveLoginAttempt(w, accountKey, ipKey) {
+		return
+	}
+	failed := false
+	defer cfg.settleLoginAttempt(accountKey, ipKey, &failed)
+
+	// every code tried uses up one of the challenge's attempts, a used up or
+	// already redeemed challenge needs a new password login
+	challengeHash := auth.HashToken(params.ChallengeToken)
+	reserved, err := cfg.dbQueries.ReserveMFAChallengeAttempt(r.Context(), database.ReserveMFAChallengeAttemptParams{
+		TokenHash:   challengeHash,
+		MaxAttempts: mfaChallengeMaxAttempts,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check challenge token", err)
+		return
+	}
+	if reserved == 0 {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge token", nil)
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(r.Context(), user, params.Code, params.RecoveryCode)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return
+	}
+	if !ok {
+		failed = true
+		cfg.recordSecurityEvent(r, user.ID, eventLoginFailed, "wrong second factor")
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return
+	}
+	// only one request gets to redeem the challenge
+	consumed, err := cfg.dbQueries.ConsumeMFAChallenge(r.Context(), challengeHash)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check challenge token", err)
+		return
+	}
+	if consumed == 0 {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge token", nil)
+		return
+	}
+	cfg.accountThrottle.Reset(accountKey)
+	if params.RecoveryCode != "" {
+		cfg.recordOwnerEvent(r, user.ID, eventLogin, "second factor: recovery code")
+	} else {
+		cfg.recordOwnerEvent(r, user.ID, eventLogin, "second factor: TOTP")
+	}
+
+	cfg.respondWithNewSession(w, r, user, params.UseCookies)
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery
+// code. Each TOTP step and each recovery code can only be used once.
+func (cfg *apiConfig) checkSecondFactor(ctx context.Context, user database.User, code, recoveryCode string) (bool, error) {
+	if !user.TotpEnabled || !user.TotpSecret.Valid {
+		return false, nil
+	}
+
+	if recoveryCode != "" {
+		used, err := cfg.dbQueries.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			UserID:   user.ID,
+			CodeHash: auth.HashToken(strings.ToLower(strings.TrimSpace(recoveryCode))),
+		})
+		if err != nil {
+			return false, err
+		}
+		return used > 0, nil
+	}
+
+	step, ok := auth.ValidateTOTP(user.TotpSecret.String, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	// reject a replay of a code that was already accepted
+	used, err := cfg.dbQueries.UseTOTPStep(ctx, database.UseTOTPStepParams{
+		ID:           user.ID,
+		TotpLastStep: step,
+	})
+	if err != nil {
+		return false, err
+	}
+	return used > 0, nil
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"net/mail"
+	"strings"
 	"time"
 
 	"github.com/cryptidcodes/chirpy/internal/auth"
@@ -17,20 +23,71 @@ type User struct {
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
 	Email          string    `json:"email"`
+	PendingEmail   string    `json:"pending_email,omitempty"`
 	HashedPassword string    `json:"-"`
 	IsChirpyRed    bool      `json:"is_chirpy_red"`
+	EmailVerified  bool      `json:"email_verified"`
+	Role           string    `json:"role"`
+}
+
+// respondWithHashError asks clients to retry shortly when all hashing slots are taken
+func respondWithHashError(w http.ResponseWriter, err error) {
+	if errors.Is(err, auth.ErrHasherBusy) {
+		w.Header().Set("Retry-After", "1")
+		respondWithError(w, http.StatusServiceUnavailable, "Server is busy, try again shortly", err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+}
+
+// respondWithPolicyError tells the client which password rule failed
+func respondWithPolicyError(w http.ResponseWriter, err error) {
+	type errorResponse struct {
+		Error string `json:"error"`
+		Rule  string `json:"rule"`
+	}
+
+	var policyErr *auth.PolicyError
+	if !errors.As(err, &policyErr) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check password", err)
+		return
+	}
+	respondWithJSON(w, http.StatusBadRequest, errorResponse{
+		Error: policyErr.Message,
+		Rule:  policyErr.Rule,
+	})
+}
+
+// normalizeEmail trims and lowercases an email address and checks that it is
+// a plain address, without a display name or angle brackets
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return "", err
+	}
+	if addr.Address != email {
+		return "", errors.New("not a plain email address")
+	}
+	return email, nil
 }
 
 func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request) {
 	// define request and response structures for this endpoint
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
+		InviteCode string `json:"invite_code"`
 	}
 	type response struct {
 		User
 	}
 
+	if cfg.registrationMode == registrationClosed {
+		respondWithError(w, http.StatusForbidden, "Registration is closed", nil)
+		return
+	}
+
 	// decode JSON request body
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
@@ -40,93 +97,311 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if cfg.registrationMode == registrationInvite && params.InviteCode == "" {
+		respondWithError(w, http.StatusForbidden, "An invite code is required to sign up", nil)
+		return
+	}
+
+	email, err := normalizeEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", err)
+		return
+	}
+
+	err = cfg.passwordPolicy.Check(params.Password, email)
+	if err != nil {
+		respondWithPolicyError(w, err)
+		return
+	}
+
 	// hash the password
-	hashedPW, err := auth.HashPassword(params.Password)
+	hashedPW, err := cfg.hasher.HashPassword(r.Context(), params.Password)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		respondWithHashError(w, err)
 		return
 	}
 
-	// create new user in database
-	newUser, err := cfg.dbQueries.CreateUser(r.Context(), database.CreateUserParams{Email: params.Email, HashedPassword: hashedPW})
+	// create new user in database. Invite codes are also accepted when
+	// registration is open, so it is still recorded who invited whom.
+	var newUser database.User
+	createParams := database.CreateUserParams{Email: email, HashedPassword: hashedPW}
+	if params.InviteCode != "" {
+		newUser, err = cfg.createUserWithInvite(r.Context(), params.InviteCode, createParams)
+	} else {
+		newUser, err = cfg.dbQueries.CreateUser(r.Context(), createParams)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusForbidden, "Invalid or expired invite code", nil)
+		return
+	}
+	// deleted accounts keep their email until they are purged
+	if isUniqueViolation(err) {
+		respondWithError(w, http.StatusConflict, "Email address is already in use", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
 		return
 	}
 
+	// new accounts can't post until they follow the link we mail them,
+	// a failed send isn't fatal since the user can ask for another one
+	err = cfg.sendVerificationEmail(r.Context(), newUser)
+	if err != nil {
+		log.Printf("Error sending verification email: %s", err)
+	}
+
 	// create and send JSON response
 	respondWithJSON(w, 201, response{
 		User: User{
-			ID:          newUser.ID,
-			CreatedAt:   newUser.CreatedAt,
-			UpdatedAt:   newUser.UpdatedAt,
-			Email:       newUser.Email,
-			IsChirpyRed: newUser.IsChirpyRed,
+			ID:            newUser.ID,
+			CreatedAt:     newUser.CreatedAt,
+			UpdatedAt:     newUser.UpdatedAt,
+			Email:         newUser.Email,
+			IsChirpyRed:   newUser.IsChirpyRed,
+			EmailVerified: newUser.EmailVerified,
+			Role:          newUser.Role,
 		},
 	})
 }
 
-func (cfg *apiConfig) handlerUpdateCredentials(w http.ResponseWriter, r *http.Request) {
+// handlerUpdateUser changes the caller's email and/or password. Fields left
+// out of the request stay as they are. Both changes need the current password,
+// so a stolen access token alone can't take the account over. A new email
+// address only replaces the old one once the link mailed to it is followed.
+func (cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request) {
 	// define request and response structures for this endpoint
 	type parameters struct {
-		Token    string `json:"token"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
 	}
 	type response struct {
 		User
 	}
 
-	// extract bearer token from Authorization header
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized", err)
-		return
-	}
+	userID := principalFromContext(r.Context()).UserID
 
 	// decode JSON request body
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	params.Token = token
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
+	if params.Email == nil && params.Password == nil {
+		respondWithError(w, http.StatusBadRequest, "Nothing to update", nil)
+		return
+	}
 
-	// validate token and get user ID
-	userID, err := auth.ValidateJWT(params.Token, cfg.secretKey)
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized", err)
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
 		return
 	}
 
-	// hash the new password
-	hashedPW, err := auth.HashPassword(params.Password)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+	// guessing the current password counts against the same limits as logging in
+	accountKey := loginAccountKey(user.Email)
+	ipKey := loginIPKey(r)
+	if !cfg.reserveLoginAttempt(w, accountKey, ipKey) {
+		return
+	}
+	failed := false
+	defer cfg.settleLoginAttempt(accountKey, ipKey, &failed)
+	match, _, err := cfg.hasher.CheckPasswordHash(r.Context(), params.CurrentPassword, user.HashedPassword)
+	if errors.Is(err, auth.ErrHasherBusy) {
+		respondWithHashError(w, err)
+		return
+	}
+	if err != nil || !match {
+		failed = true
+		respondWithError(w, http.StatusUnauthorized, "Current password is incorrect", err)
 		return
 	}
 
-	// update user credentials in database
-	updatedUser, err := cfg.dbQueries.UpdateUserCredentials(r.Context(), database.UpdateUserCredentialsParams{
-		Email:          params.Email,
-		HashedPassword: hashedPW,
-		ID:             userID,
-	})
+	// check everything before changing anything
+	if params.Password != nil {
+		err = cfg.passwordPolicy.Check(*params.Password, user.Email)
+		if err != nil {
+			respondWithPolicyError(w, err)
+			return
+		}
+	}
+
+	newEmail := ""
+	if params.Email != nil {
+		newEmail, err = normalizeEmail(*params.Email)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid email address", err)
+			return
+		}
+		if strings.EqualFold(newEmail, user.Email) {
+			newEmail = ""
+		}
+	}
+	if newEmail != "" {
+		_, err = cfg.dbQueries.GetUserByEmail(r.Context(), newEmail)
+		if err == nil {
+			respondWithError(w, http.StatusConflict, "Email address is already in use", nil)
+			return
+		}
+	}
+
+	// hash before the transaction so a slow hash doesn't hold it open
+	hashedPW := ""
+	if params.Password != nil {
+		hashedPW, err = cfg.hasher.HashPassword(r.Context(), *params.Password)
+		if err != nil {
+			respondWithHashError(w, err)
+			return
+		}
+	}
+
+	// a browser session making the change stays logged in, every other one is logged out
+	keepFamily := uuid.NullUUID{}
+	if c, err := r.Cookie(refreshCookie); err == nil {
+		stored, err := cfg.dbQueries.GetLiveRefreshToken(r.Context(), c.Value)
+		if err == nil && stored.UserID == user.ID {
+			keepFamily = uuid.NullUUID{UUID: stored.FamilyID, Valid: true}
+		}
+	}
+
+	user, err = cfg.updateUser(r.Context(), user.ID, hashedPW, newEmail, keepFamily)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update user credentials", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
 		return
 	}
+	if hashedPW != "" {
+		cfg.recordSecurityEvent(r, user.ID, eventCredentialsChanged, "password changed")
+	}
+	if newEmail != "" {
+		cfg.recordSecurityEvent(r, user.ID, eventEmailChangeRequested, "new address: "+newEmail)
+
+		// the change is already saved, a failed send isn't fatal since asking again sends new emails
+		err = cfg.sendEmailChangeEmails(r.Context(), user)
+		if err != nil {
+			log.Printf("Error sending email change emails: %s", err)
+		}
+	}
 
 	// create and send JSON response
 	respondWithJSON(w, 200, response{
 		User: User{
-			ID:          updatedUser.ID,
-			CreatedAt:   updatedUser.CreatedAt,
-			UpdatedAt:   updatedUser.UpdatedAt,
-			Email:       updatedUser.Email,
-			IsChirpyRed: updatedUser.IsChirpyRed,
+			ID:            user.ID,
+			CreatedAt:     user.CreatedAt,
+			UpdatedAt:     user.UpdatedAt,
+			Email:         user.Email,
+			PendingEmail:  user.PendingEmail.String,
+			IsChirpyRed:   user.IsChirpyRed,
+			EmailVerified: user.EmailVerified,
+			Role:          user.Role,
+		},
+	})
+}
+
+// updateUser stores a new password and/or pending email in one transaction.
+// A new password logs the user out of every session except keepFamily, if
+// set, and makes outstanding reset links stale, like a password reset does.
+func (cfg *apiConfig) updateUser(ctx context.Context, userID uuid.UUID, hashedPassword, newEmail string, keepFamily uuid.NullUUID) (database.User, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	if hashedPassword != "" {
+		err = qtx.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{
+			ID:             userID,
+			HashedPassword: hashedPassword,
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+
+		if keepFamily.Valid {
+			err = qtx.RevokeOtherSessions(ctx, database.RevokeOtherSessionsParams{
+				UserID:   userID,
+				FamilyID: keepFamily.UUID,
+			})
+		} else {
+			err = qtx.RevokeAllRefreshTokensForUser(ctx, userID)
+		}
+		if err != nil {
+			return database.User{}, err
+		}
+
+		err = qtx.InvalidatePasswordResetTokens(ctx, userID)
+		if err != nil {
+			return database.User{}, err
+		}
+	}
+
+	if newEmail != "" {
+		_, err = qtx.SetPendingEmail(ctx, database.SetPendingEmailParams{
+			ID:           userID,
+			PendingEmail: sql.NullString{String: newEmail, Valid: true},
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+	}
+
+	user, err := qtx.GetUserByID(ctx, userID)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	return user, tx.Commit()
+}
+
+func (cfg *apiConfig) handlerSetUserRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+	type response struct {
+		User
+	}
+
+	// extract userID from URL
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	// decode JSON request body
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+	if _, ok := roleRank[params.Role]; !ok {
+		respondWithError(w, http.StatusBadRequest, "Role must be one of user, moderator or admin", nil)
+		return
+	}
+
+	updatedUser, err := cfg.dbQueries.SetUserRole(r.Context(), database.SetUserRoleParams{
+		ID:   userID,
+		Role: params.Role,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	cfg.recordSecurityEvent(r, updatedUser.ID, eventAdminRoleChanged, "role: "+params.Role)
+
+	respondWithJSON(w, http.StatusOK, response{
+		User: User{
+			ID:            updatedUser.ID,
+			CreatedAt:     updatedUser.CreatedAt,
+			UpdatedAt:     updatedUser.UpdatedAt,
+			Email:         updatedUser.Email,
+			IsChirpyRed:   updatedUser.IsChirpyRed,
+			EmailVerified: updatedUser.EmailVerified,
+			Role:          updatedUser.Role,
 		},
 	})
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cryptidcodes/chirpy/internal/auth"
+	"github.com/cryptidcodes/chirpy/internal/database"
+	"github.com/cryptidcodes/chirpy/internal/mailer"
+)
+
+// verification links stay valid for a day
+const emailVerificationDuration = 24 * time.Hour
+
+// sendVerificationEmail mails the user a signed link that is only valid for their current address
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	token, err := auth.MakePurposeJWT(user.ID, auth.PurposeVerifyEmail, strings.ToLower(user.Email), cfg.jwtKeys, emailVerificationDuration)
+	if err != nil {
+		return err
+	}
+
+	link := cfg.appURL + "/verify-email?token=" + url.QueryEscape(token)
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Welcome to Chirpy!\n\n"+
+			"Follow this link to verify your email address:\n\n%s\n\n"+
+			"The link expires in 24 hours. If you didn't create a Chirpy account, you can ignore this email.\n", link),
+	})
+}
+
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+	type response struct {
+		User
+	}
+
+	// decode JSON request body
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	verification, err := auth.ValidatePurposeJWT(params.Token, auth.PurposeVerifyEmail, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token", err)
+		return
+	}
+
+	// the link is only good for the address it was sent to
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), verification.UserID)
+	if err != nil || !verification.IsBoundTo(strings.ToLower(user.Email)) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token", err)
+		return
+	}
+
+	verified, err := cfg.dbQueries.MarkEmailVerified(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		User: User{
+			ID:            verified.ID,
+			CreatedAt:     verified.CreatedAt,
+			UpdatedAt:     verified.UpdatedAt,
+			Email:         verified.Email,
+			IsChirpyRed:   verified.IsChirpyRed,
+			EmailVerified: verified.EmailVerified,
+			Role:          verified.Role,
+		},
+	})
+}
+
+func (cfg *apiConfig) handlerResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID := principalFromContext(r.Context()).UserID
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	if user.EmailVerified {
+		respondWithError(w, http.StatusConflict, "Email address is already verified", nil)
+		return
+	}
+
+	err = cfg.sendVerificationEmail(r.Context(), user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}